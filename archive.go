@@ -0,0 +1,723 @@
+package gnsys
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// CompressOption is a function that configures creation of archives and
+// compressed files.
+type CompressOption func(*compressConfig)
+
+// compressConfig keeps settings that modify behavior of compression.
+type compressConfig struct {
+	// level is the compression level from 1 to 9, 0 means the default
+	// level of the format.
+	level int
+
+	// include and exclude are glob patterns that select files to archive.
+	include, exclude []string
+
+	// followSymlinks archives files symbolic links point to instead of
+	// the links.
+	followSymlinks bool
+
+	// deflateAll compresses zip entries even if they are compressed
+	// already.
+	deflateAll bool
+
+	// removeSrc removes the original file after it is compressed.
+	removeSrc bool
+}
+
+// newCompressConfig creates a configuration with default settings modified
+// by options.
+func newCompressConfig(opts []CompressOption) compressConfig {
+	var res compressConfig
+	for _, opt := range opts {
+		opt(&res)
+	}
+	return res
+}
+
+// OptCompressLevel sets the compression level from 1 (fastest) to 9 (best).
+// Zero, the default, uses the default level of the format. The level is
+// ignored for plain tar archives.
+func OptCompressLevel(level int) CompressOption {
+	return func(cfg *compressConfig) {
+		cfg.level = level
+	}
+}
+
+// OptCompressInclude limits archived files to the ones matching at least
+// one of the glob patterns (see path.Match). A pattern is matched against
+// the slash-separated name of an entry in the archive and against its base
+// name. If a directory matches, all its content is included. Directories
+// leading to included files are added automatically.
+func OptCompressInclude(patterns ...string) CompressOption {
+	return func(cfg *compressConfig) {
+		cfg.include = append(cfg.include, patterns...)
+	}
+}
+
+// OptCompressExclude leaves out files and directories matching any of the
+// glob patterns. Patterns are matched the same way as in OptCompressInclude.
+// Exclusion takes precedence over inclusion.
+func OptCompressExclude(patterns ...string) CompressOption {
+	return func(cfg *compressConfig) {
+		cfg.exclude = append(cfg.exclude, patterns...)
+	}
+}
+
+// OptCompressFollowSymlinks sets whether symbolic links are replaced by the
+// files and directories they point to. By default links are archived as
+// links, and their targets must satisfy the same rules extraction applies:
+// they must be relative and stay inside the archive.
+func OptCompressFollowSymlinks(b bool) CompressOption {
+	return func(cfg *compressConfig) {
+		cfg.followSymlinks = b
+	}
+}
+
+// OptZipStoreCompressed sets whether files that are compressed already
+// (archives, compressed files, most image, audio and video formats) are
+// stored in zip archives without compression. It is enabled by default,
+// because deflating such files wastes time without reducing their size.
+func OptZipStoreCompressed(b bool) CompressOption {
+	return func(cfg *compressConfig) {
+		cfg.deflateAll = !b
+	}
+}
+
+// OptCompressRemoveSource sets whether single-file compressors (CompressGz
+// etc.) remove the original file after successful compression, like
+// the gzip command does. By default the original is kept.
+func OptCompressRemoveSource(b bool) CompressOption {
+	return func(cfg *compressConfig) {
+		cfg.removeSrc = b
+	}
+}
+
+// compressedExts are extensions of files that do not benefit from another
+// round of compression.
+var compressedExts = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".lz4": true, ".br": true, ".z": true, ".zip": true, ".7z": true,
+	".rar": true, ".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+	".webp": true, ".mp3": true, ".mp4": true, ".mkv": true, ".ogg": true,
+}
+
+// CreateArchive archives files and directories at srcPaths into dstPath,
+// choosing the format by the extension of dstPath (see GetFileType). Zip
+// and tar archives, plain or compressed with gzip, bzip2, xz, zstd or lz4,
+// are supported.
+func CreateArchive(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	ft := GetFileType(dstPath)
+	switch ft {
+	case ZipFT:
+		return CreateZip(srcPaths, dstPath, opts...)
+	case TarFT, TarGzFT, TarBzFT, TarXzFt, TarZstFT, TarLz4FT:
+		return createTarFile(srcPaths, dstPath, ft, opts)
+	default:
+		err := fmt.Errorf("cannot create archive of file type '%s'", ft)
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+}
+
+// CreateTar archives files and directories at srcPaths into a tar archive
+// dstPath. Every source is stored under its base name, so archiving the
+// directory "path/to/data" creates entries "data/...".
+func CreateTar(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	return createTarFile(srcPaths, dstPath, TarFT, opts)
+}
+
+// CreateTarGz archives files and directories at srcPaths into a tar.gz
+// archive dstPath.
+func CreateTarGz(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	return createTarFile(srcPaths, dstPath, TarGzFT, opts)
+}
+
+// CreateTarBz2 archives files and directories at srcPaths into a tar.bz2
+// archive dstPath.
+func CreateTarBz2(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	return createTarFile(srcPaths, dstPath, TarBzFT, opts)
+}
+
+// CreateTarXz archives files and directories at srcPaths into a tar.xz
+// archive dstPath.
+func CreateTarXz(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	return createTarFile(srcPaths, dstPath, TarXzFt, opts)
+}
+
+// CreateTarZst archives files and directories at srcPaths into a tar.zst
+// archive dstPath.
+func CreateTarZst(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	return createTarFile(srcPaths, dstPath, TarZstFT, opts)
+}
+
+// CreateTarLz4 archives files and directories at srcPaths into a tar.lz4
+// archive dstPath.
+func CreateTarLz4(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	return createTarFile(srcPaths, dstPath, TarLz4FT, opts)
+}
+
+// CreateZip archives files and directories at srcPaths into a zip archive
+// dstPath. Files that are compressed already are stored as is, unless
+// OptZipStoreCompressed(false) is given.
+func CreateZip(srcPaths []string, dstPath string, opts ...CompressOption) error {
+	cfg := newCompressConfig(opts)
+	return createArchive(srcPaths, dstPath, cfg,
+		func(w io.Writer, entries []walkEntry) error {
+			zw := zip.NewWriter(w)
+			if cfg.level != 0 {
+				zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+					return flate.NewWriter(w, cfg.level)
+				})
+			}
+			for _, v := range entries {
+				if err := writeZipEntry(zw, v, cfg); err != nil {
+					return &ErrArchive{Path: dstPath, Entry: v.name, Err: err}
+				}
+			}
+			return zw.Close()
+		})
+}
+
+// CompressGz compresses the file at srcPath into dstDir/<name>.gz.
+// The gzip header keeps the name and the modification time of the file.
+func CompressGz(srcPath, dstDir string, opts ...CompressOption) error {
+	return compressFile(srcPath, dstDir, GzFT, opts)
+}
+
+// CompressBz2 compresses the file at srcPath into dstDir/<name>.bz2.
+func CompressBz2(srcPath, dstDir string, opts ...CompressOption) error {
+	return compressFile(srcPath, dstDir, Bz2FT, opts)
+}
+
+// CompressXz compresses the file at srcPath into dstDir/<name>.xz.
+func CompressXz(srcPath, dstDir string, opts ...CompressOption) error {
+	return compressFile(srcPath, dstDir, XzFT, opts)
+}
+
+// CompressZst compresses the file at srcPath into dstDir/<name>.zst.
+func CompressZst(srcPath, dstDir string, opts ...CompressOption) error {
+	return compressFile(srcPath, dstDir, ZstFT, opts)
+}
+
+// CompressLz4 compresses the file at srcPath into dstDir/<name>.lz4.
+func CompressLz4(srcPath, dstDir string, opts ...CompressOption) error {
+	return compressFile(srcPath, dstDir, Lz4FT, opts)
+}
+
+// CompressBr compresses the file at srcPath into dstDir/<name>.br.
+func CompressBr(srcPath, dstDir string, opts ...CompressOption) error {
+	return compressFile(srcPath, dstDir, BrFT, opts)
+}
+
+// compressExts are extensions added by single-file compressors.
+var compressExts = map[FileType]string{
+	GzFT:  ".gz",
+	Bz2FT: ".bz2",
+	XzFT:  ".xz",
+	ZstFT: ".zst",
+	Lz4FT: ".lz4",
+	BrFT:  ".br",
+}
+
+// compressFile compresses a single file into dstDir, adding the extension
+// of the format to its name. The result gets permissions and modification
+// time of the original. If compression fails, the partial result is
+// removed and the original is kept.
+func compressFile(
+	srcPath, dstDir string,
+	ft FileType,
+	opts []CompressOption,
+) (err error) {
+	cfg := newCompressConfig(opts)
+
+	exists, err := FileExists(srcPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &ErrFileMissing{Path: srcPath}
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return &ErrArchive{Path: srcPath, Err: err}
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return &ErrArchive{Path: srcPath, Err: err}
+	}
+
+	if err = os.MkdirAll(dstDir, 0755); err != nil {
+		return &ErrArchive{Path: dstDir, Err: err}
+	}
+	dstPath := filepath.Join(dstDir, info.Name()+compressExts[ft])
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil && cerr != nil {
+			err = &ErrArchive{Path: dstPath, Err: cerr}
+		}
+		if err != nil {
+			os.Remove(dstPath)
+		}
+	}()
+
+	bw := bufio.NewWriter(dst)
+	cw, err := newCompressor(bw, ft, cfg.level)
+	if err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	if gz, ok := cw.(*gzip.Writer); ok {
+		gz.Name = info.Name()
+		gz.ModTime = info.ModTime()
+	}
+	if _, err = io.Copy(cw, src); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	if err = cw.Close(); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	if err = bw.Flush(); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	if err = dst.Chmod(info.Mode().Perm()); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	if err = os.Chtimes(dstPath, info.ModTime(), info.ModTime()); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+
+	if cfg.removeSrc {
+		src.Close()
+		if err = os.Remove(srcPath); err != nil {
+			return &ErrArchive{Path: srcPath, Err: err}
+		}
+	}
+	return nil
+}
+
+// createTarFile creates a tar archive compressed according to the file type.
+func createTarFile(
+	srcPaths []string,
+	dstPath string,
+	ft FileType,
+	opts []CompressOption,
+) error {
+	cfg := newCompressConfig(opts)
+	return createArchive(srcPaths, dstPath, cfg,
+		func(w io.Writer, entries []walkEntry) error {
+			cw, err := newCompressor(w, ft, cfg.level)
+			if err != nil {
+				return err
+			}
+			tw := tar.NewWriter(cw)
+			for _, v := range entries {
+				if err = writeTarEntry(tw, v); err != nil {
+					return &ErrArchive{Path: dstPath, Entry: v.name, Err: err}
+				}
+			}
+			if err = tw.Close(); err != nil {
+				return err
+			}
+			return cw.Close()
+		})
+}
+
+// createArchive collects entries from srcPaths and writes them into
+// dstPath with the given function. If it fails, dstPath is removed.
+func createArchive(
+	srcPaths []string,
+	dstPath string,
+	cfg compressConfig,
+	write func(io.Writer, []walkEntry) error,
+) (err error) {
+	if err = checkPatterns(slices.Concat(cfg.include, cfg.exclude)); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	for _, v := range srcPaths {
+		if _, err = os.Lstat(v); err != nil {
+			return &ErrFileMissing{Path: v}
+		}
+	}
+
+	f, err := os.Create(dstPath)
+	if err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = &ErrArchive{Path: dstPath, Err: cerr}
+		}
+		if err != nil {
+			os.Remove(dstPath)
+		}
+	}()
+
+	// The archive must not include itself if it is created inside one of
+	// the archived directories.
+	self, err := f.Stat()
+	if err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+
+	c := collector{cfg: cfg, self: self}
+	for _, v := range srcPaths {
+		if err = c.add(v); err != nil {
+			return &ErrArchive{Path: dstPath, Err: err}
+		}
+	}
+
+	bw := bufio.NewWriter(f)
+	if err = write(bw, c.selected()); err != nil {
+		var errArchive *ErrArchive
+		if !errors.As(err, &errArchive) {
+			err = &ErrArchive{Path: dstPath, Err: err}
+		}
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return &ErrArchive{Path: dstPath, Err: err}
+	}
+	return nil
+}
+
+// walkEntry is a file system object to be written into an archive.
+type walkEntry struct {
+	// path is the location of the object in the file system.
+	path string
+
+	// name is the slash-separated name of the entry in the archive.
+	name string
+
+	// info describes the object, or the target of a followed link.
+	info fs.FileInfo
+
+	// link is the target of a symbolic link.
+	link string
+
+	// included is true if the entry passed include patterns.
+	included bool
+}
+
+// collector walks source paths and collects entries for an archive.
+type collector struct {
+	cfg compressConfig
+
+	// self is the archive being created.
+	self fs.FileInfo
+
+	// visited keeps real paths of directories reached through followed
+	// symbolic links, to break cycles.
+	visited map[string]bool
+
+	// root is the parent directory of the source path being collected.
+	// Symbolic links are checked against it the way extraction checks
+	// them against its destination.
+	root *os.Root
+
+	entries []walkEntry
+}
+
+// add collects a source path and, if it is a directory, its content.
+// The path is stored in the archive under its base name.
+func (c *collector) add(srcPath string) error {
+	abs, err := filepath.Abs(srcPath)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(abs)
+	if name == string(filepath.Separator) {
+		return fmt.Errorf("cannot archive root directory '%s'", srcPath)
+	}
+
+	// Names of entries are relative to the parent of the source path.
+	c.root, err = os.OpenRoot(filepath.Dir(abs))
+	if err != nil {
+		return err
+	}
+	defer c.root.Close()
+	return c.walk(abs, name, len(c.cfg.include) == 0)
+}
+
+// walk collects an entry and, for directories, all entries inside.
+func (c *collector) walk(fsPath, name string, included bool) error {
+	if matchName(c.cfg.exclude, name) {
+		return nil
+	}
+	included = included || matchName(c.cfg.include, name)
+
+	info, err := os.Lstat(fsPath)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(info, c.self) {
+		return nil
+	}
+
+	entry := walkEntry{path: fsPath, name: name, info: info, included: included}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if c.cfg.followSymlinks {
+			return c.follow(fsPath, name, included)
+		}
+		if entry.link, err = os.Readlink(fsPath); err != nil {
+			return err
+		}
+		if err = checkSymlink(c.root, name, entry.link, "archive"); err != nil {
+			return fmt.Errorf("entry '%s': %w", name, err)
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		c.entries = append(c.entries, entry)
+		return c.walkDir(fsPath, name, included)
+	case info.Mode().IsRegular(), info.Mode()&os.ModeSymlink != 0:
+		c.entries = append(c.entries, entry)
+	default:
+		slog.Warn("skipping file of unsupported type",
+			"path", fsPath, "mode", info.Mode().String())
+	}
+	return nil
+}
+
+// follow collects the object a symbolic link points to under the name of
+// the link.
+func (c *collector) follow(fsPath, name string, included bool) error {
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		return err
+	}
+	entry := walkEntry{path: fsPath, name: name, info: info, included: included}
+
+	switch {
+	case info.IsDir():
+		real, err := filepath.EvalSymlinks(fsPath)
+		if err != nil {
+			return err
+		}
+		if c.visited == nil {
+			c.visited = make(map[string]bool)
+		}
+		if c.visited[real] {
+			return fmt.Errorf("symlink loop at '%s'", fsPath)
+		}
+		c.visited[real] = true
+		defer delete(c.visited, real)
+
+		c.entries = append(c.entries, entry)
+		return c.walkDir(fsPath, name, included)
+	case info.Mode().IsRegular():
+		c.entries = append(c.entries, entry)
+	default:
+		slog.Warn("skipping file of unsupported type",
+			"path", fsPath, "mode", info.Mode().String())
+	}
+	return nil
+}
+
+// walkDir collects the content of a directory in lexical order.
+func (c *collector) walkDir(fsPath, name string, included bool) error {
+	items, err := os.ReadDir(fsPath)
+	if err != nil {
+		return err
+	}
+	for _, v := range items {
+		err = c.walk(filepath.Join(fsPath, v.Name()), path.Join(name, v.Name()), included)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selected returns collected entries that passed include patterns, together
+// with directories that contain them.
+func (c *collector) selected() []walkEntry {
+	if len(c.cfg.include) == 0 {
+		return c.entries
+	}
+
+	keep := make(map[string]bool)
+	for _, v := range c.entries {
+		if !v.included {
+			continue
+		}
+		for name := v.name; name != "."; name = path.Dir(name) {
+			keep[name] = true
+		}
+	}
+
+	var res []walkEntry
+	for _, v := range c.entries {
+		if keep[v.name] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// writeTarEntry writes the header and content of an entry into a tar archive.
+func writeTarEntry(tw *tar.Writer, entry walkEntry) error {
+	header, err := tar.FileInfoHeader(entry.info, filepath.ToSlash(entry.link))
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	if entry.info.IsDir() {
+		header.Name += "/"
+	}
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !entry.info.Mode().IsRegular() {
+		return nil
+	}
+	return copyFile(tw, entry.path)
+}
+
+// writeZipEntry writes the header and content of an entry into a zip
+// archive. Symbolic links are stored the way Info-ZIP does it, with the
+// target as the content of the entry.
+func writeZipEntry(zw *zip.Writer, entry walkEntry, cfg compressConfig) error {
+	header, err := zip.FileInfoHeader(entry.info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	switch {
+	case entry.info.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+	case entry.link != "":
+		header.Method = zip.Store
+	case !cfg.deflateAll &&
+		compressedExts[strings.ToLower(filepath.Ext(entry.name))]:
+		header.Method = zip.Store
+	default:
+		header.Method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case entry.link != "":
+		_, err = io.WriteString(w, filepath.ToSlash(entry.link))
+		return err
+	case entry.info.Mode().IsRegular():
+		return copyFile(w, entry.path)
+	}
+	return nil
+}
+
+// copyFile copies the content of a file into w. The file is closed before
+// it returns, so archiving many files keeps only one of them open.
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// xzDictCaps are dictionary sizes of xz presets from 0 to 9.
+var xzDictCaps = []int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// lz4Levels are lz4 compression levels that correspond to levels 1 to 9.
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+	lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// newCompressor wraps a writer into a writer that compresses data in the
+// format of the given file type. Plain tar data is written as is. Level 0
+// means the default level of the format.
+func newCompressor(w io.Writer, ft FileType, level int) (io.WriteCloser, error) {
+	if level < 0 || level > 9 {
+		return nil, fmt.Errorf("compression level %d is not between 0 and 9", level)
+	}
+
+	switch ft {
+	case GzFT, TarGzFT:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Bz2FT, TarBzFT:
+		return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+	case XzFT, TarXzFt:
+		var xzCfg xz.WriterConfig
+		if level != 0 {
+			xzCfg.DictCap = xzDictCaps[level]
+		}
+		return xzCfg.NewWriter(w)
+	case ZstFT, TarZstFT:
+		zstLevel := zstd.SpeedDefault
+		if level != 0 {
+			// zstd levels go up to 22, spread 1-9 over them.
+			zstLevel = zstd.EncoderLevelFromZstd(level * 22 / 9)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstLevel))
+	case Lz4FT, TarLz4FT:
+		lz4Writer := lz4.NewWriter(w)
+		if level != 0 {
+			err := lz4Writer.Apply(lz4.CompressionLevelOption(lz4Levels[level-1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return lz4Writer, nil
+	case BrFT:
+		if level == 0 {
+			level = brotli.DefaultCompression
+		} else {
+			// brotli levels go up to 11.
+			level = level * brotli.BestCompression / 9
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	case TarFT:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("no compressor for file type '%s'", ft)
+	}
+}
+
+// nopWriteCloser adds a Close method that does nothing to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
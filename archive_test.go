@@ -0,0 +1,263 @@
+package gnsys_test
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gnames/gnsys"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeSrcDir creates a directory tree for archiving tests and returns
+// the path to its top directory "data".
+func makeSrcDir(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "data")
+	files := map[string]string{
+		"a.txt":       "alpha\n",
+		"sub/c.txt":   "gamma\n",
+		"sub/d.log":   "delta\n",
+		"skip/e.txt":  "epsilon\n",
+		"pack/f.gz":   "not really gzip",
+		"pack/g.json": `{"a": 1}`,
+	}
+	for k, v := range files {
+		path := filepath.Join(dir, filepath.FromSlash(k))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCreateArchive(t *testing.T) {
+	assert := assert.New(t)
+	srcDir := makeSrcDir(t)
+
+	for _, ext := range []string{
+		"tar", "tar.gz", "tar.bz2", "tar.xz", "tar.zst", "tar.lz4", "zip",
+	} {
+		path := filepath.Join(t.TempDir(), "data."+ext)
+		err := gnsys.CreateArchive([]string{srcDir}, path,
+			gnsys.OptCompressLevel(9))
+		assert.Nil(err, ext)
+
+		dstDir := t.TempDir()
+		err = gnsys.Extract(path, dstDir)
+		assert.Nil(err, ext)
+
+		res, err := os.ReadFile(filepath.Join(dstDir, "data", "sub", "c.txt"))
+		assert.Nil(err, ext)
+		assert.Equal("gamma\n", string(res), ext)
+
+		link, err := os.Readlink(filepath.Join(dstDir, "data", "link.txt"))
+		assert.Nil(err, ext)
+		assert.Equal("a.txt", link, ext)
+	}
+
+	err := gnsys.CreateArchive([]string{srcDir}, filepath.Join(t.TempDir(), "a.gz"))
+	assert.IsType(&gnsys.ErrArchive{}, err)
+
+	err = gnsys.CreateTar([]string{"nowhere"}, filepath.Join(t.TempDir(), "a.tar"))
+	assert.IsType(&gnsys.ErrFileMissing{}, err)
+}
+
+func TestCreateArchiveFilter(t *testing.T) {
+	assert := assert.New(t)
+	srcDir := makeSrcDir(t)
+	path := filepath.Join(t.TempDir(), "data.tar.gz")
+
+	err := gnsys.CreateTarGz([]string{srcDir}, path,
+		gnsys.OptCompressInclude("*.txt", "pack"),
+		gnsys.OptCompressExclude("skip", "link.txt"),
+	)
+	assert.Nil(err)
+
+	dstDir := t.TempDir()
+	err = gnsys.ExtractTarGz(path, dstDir)
+	assert.Nil(err)
+
+	for k, v := range map[string]bool{
+		"data/a.txt":       true,
+		"data/sub/c.txt":   true,
+		"data/sub/d.log":   false,
+		"data/skip/e.txt":  false,
+		"data/link.txt":    false,
+		"data/pack/f.gz":   true,
+		"data/pack/g.json": true,
+	} {
+		_, err := os.Lstat(filepath.Join(dstDir, filepath.FromSlash(k)))
+		assert.Equal(v, err == nil, k)
+	}
+	assert.False(gnsys.IsDir(filepath.Join(dstDir, "data", "skip")))
+
+	err = gnsys.CreateTarGz([]string{srcDir}, path, gnsys.OptCompressExclude("["))
+	assert.IsType(&gnsys.ErrArchive{}, err)
+}
+
+func TestCreateArchiveSymlinks(t *testing.T) {
+	assert := assert.New(t)
+	srcDir := makeSrcDir(t)
+	err := os.Symlink("../../outside.txt", filepath.Join(srcDir, "sub", "up.txt"))
+	assert.Nil(err)
+	err = os.WriteFile(filepath.Join(filepath.Dir(srcDir), "outside.txt"),
+		[]byte("outside\n"), 0644)
+	assert.Nil(err)
+	err = os.Symlink("/etc/hostname", filepath.Join(srcDir, "abs.txt"))
+	assert.Nil(err)
+
+	// the absolute link would be rejected by extraction
+	path := filepath.Join(t.TempDir(), "data.tar")
+	err = gnsys.CreateTar([]string{srcDir}, path)
+	assert.IsType(&gnsys.ErrArchive{}, err)
+	assert.Contains(err.Error(), "abs.txt")
+	assert.False(gnsys.IsFile(path))
+
+	err = gnsys.CreateTar([]string{srcDir}, path,
+		gnsys.OptCompressExclude("abs.txt"))
+	assert.Nil(err)
+
+	// a chain of links leads outside
+	err = os.Symlink("..", filepath.Join(srcDir, "sub", "s"))
+	assert.Nil(err)
+	err = os.Symlink("sub/s/..", filepath.Join(srcDir, "t"))
+	assert.Nil(err)
+	err = gnsys.CreateTar([]string{srcDir}, path,
+		gnsys.OptCompressExclude("abs.txt"))
+	assert.IsType(&gnsys.ErrArchive{}, err)
+	assert.Contains(err.Error(), "data/t")
+	assert.Nil(os.Remove(filepath.Join(srcDir, "t")))
+	assert.Nil(os.Remove(filepath.Join(srcDir, "sub", "s")))
+
+	err = gnsys.CreateTar([]string{srcDir}, path,
+		gnsys.OptCompressExclude("abs.txt"),
+		gnsys.OptCompressFollowSymlinks(true),
+	)
+	assert.Nil(err)
+	dstDir := t.TempDir()
+	err = gnsys.ExtractTar(path, dstDir)
+	assert.Nil(err)
+	for k, v := range map[string]string{
+		"link.txt":   "alpha\n",
+		"sub/up.txt": "outside\n",
+	} {
+		path := filepath.Join(dstDir, "data", filepath.FromSlash(k))
+		fi, err := os.Lstat(path)
+		if assert.Nil(err, k) {
+			assert.True(fi.Mode().IsRegular(), k)
+		}
+		res, err := os.ReadFile(path)
+		assert.Nil(err, k)
+		assert.Equal(v, string(res), k)
+	}
+}
+
+func TestCreateZipStore(t *testing.T) {
+	assert := assert.New(t)
+	srcDir := makeSrcDir(t)
+
+	methods := func(path string) map[string]uint16 {
+		r, err := zip.OpenReader(path)
+		assert.Nil(err)
+		defer r.Close()
+		res := make(map[string]uint16)
+		for _, f := range r.File {
+			res[f.Name] = f.Method
+		}
+		return res
+	}
+
+	// the archive is created inside the archived directory, but does not
+	// include itself
+	path := filepath.Join(srcDir, "data.zip")
+	err := gnsys.CreateZip([]string{srcDir}, path)
+	assert.Nil(err)
+	res := methods(path)
+	assert.Equal(zip.Store, res["data/pack/f.gz"])
+	assert.Equal(zip.Deflate, res["data/pack/g.json"])
+	assert.NotContains(res, "data/data.zip")
+
+	err = gnsys.CreateZip([]string{srcDir}, path,
+		gnsys.OptZipStoreCompressed(false))
+	assert.Nil(err)
+	res = methods(path)
+	assert.Equal(zip.Deflate, res["data/pack/f.gz"])
+}
+
+func TestCompress(t *testing.T) {
+	assert := assert.New(t)
+	content, err := os.ReadFile(filepath.Join("testdata", "text.txt"))
+	assert.Nil(err)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	srcDir := t.TempDir()
+	srcPath := filepath.Join(srcDir, "text.txt")
+	err = os.WriteFile(srcPath, content, 0640)
+	assert.Nil(err)
+	err = os.Chtimes(srcPath, mtime, mtime)
+	assert.Nil(err)
+
+	tests := []struct {
+		ext      string
+		compress func(string, string, ...gnsys.CompressOption) error
+	}{
+		{"gz", gnsys.CompressGz},
+		{"bz2", gnsys.CompressBz2},
+		{"xz", gnsys.CompressXz},
+		{"zst", gnsys.CompressZst},
+		{"lz4", gnsys.CompressLz4},
+		{"br", gnsys.CompressBr},
+	}
+	for _, v := range tests {
+		for _, level := range []int{0, 1, 9} {
+			dstDir := filepath.Join(t.TempDir(), "out")
+			err = v.compress(srcPath, dstDir, gnsys.OptCompressLevel(level))
+			assert.Nil(err, v.ext)
+
+			path := filepath.Join(dstDir, "text.txt."+v.ext)
+			fi, err := os.Stat(path)
+			assert.Nil(err, v.ext)
+			assert.Equal(os.FileMode(0640), fi.Mode().Perm(), v.ext)
+			assert.True(mtime.Equal(fi.ModTime()), v.ext)
+
+			extDir := t.TempDir()
+			err = gnsys.Extract(path, extDir)
+			assert.Nil(err, v.ext)
+			res, err := os.ReadFile(filepath.Join(extDir, "text.txt"))
+			assert.Nil(err, v.ext)
+			assert.Equal(content, res, v.ext)
+		}
+	}
+
+	dstDir := t.TempDir()
+	err = gnsys.CompressGz(srcPath, dstDir, gnsys.OptCompressLevel(10))
+	assert.IsType(&gnsys.ErrArchive{}, err)
+	assert.False(gnsys.IsFile(filepath.Join(dstDir, "text.txt.gz")))
+
+	err = gnsys.CompressGz(srcPath, dstDir, gnsys.OptCompressRemoveSource(true))
+	assert.Nil(err)
+	assert.False(gnsys.IsFile(srcPath))
+
+	f, err := os.Open(filepath.Join(dstDir, "text.txt.gz"))
+	assert.Nil(err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.Nil(err)
+	assert.Equal("text.txt", gz.Name)
+	assert.True(mtime.Equal(gz.ModTime))
+
+	err = gnsys.CompressXz(srcPath, dstDir)
+	assert.IsType(&gnsys.ErrFileMissing{}, err)
+	err = gnsys.CompressXz(srcDir, dstDir)
+	assert.IsType(&gnsys.ErrNotFile{}, err)
+}
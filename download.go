@@ -1,18 +1,106 @@
 package gnsys
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
 )
 
+// DownloadOption is a function that configures Download.
+type DownloadOption func(*downloadConfig)
+
+// downloadConfig keeps settings that modify behavior of Download.
+type downloadConfig struct {
+	// resume continues an interrupted download, appending to an existing
+	// partial file instead of starting from scratch.
+	resume bool
+
+	// sshKeyFiles are private key files used for sftp:// authentication.
+	sshKeyFiles []string
+
+	// knownHostsFile is a known_hosts file used to verify sftp:// servers.
+	knownHostsFile string
+
+	// s3Endpoint is the URL of S3-compatible storage.
+	s3Endpoint string
+
+	// s3Region is the region used to sign S3 requests.
+	s3Region string
+
+	// s3Workers is the number of parallel range requests for s3:// URLs.
+	s3Workers int
+
+	// s3PartSize is the size of one range request for s3:// URLs.
+	s3PartSize int64
+
+	// sha256 is the expected hex-encoded SHA-256 checksum of the downloaded
+	// data.
+	sha256 string
+
+	// extractOpts configure extraction of DownloadExtract.
+	extractOpts []ExtractOption
+}
+
+// OptResume sets Download to continue a previously interrupted transfer.
+// If a partial file exists at the destination, only the missing remainder
+// is fetched and appended to it. Supported for file://, http(s):// (via
+// Range requests), sftp:// and s3:// URLs.
+func OptResume(b bool) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.resume = b
+	}
+}
+
+// OptSHA256 sets the expected SHA-256 checksum (hex-encoded) of the
+// downloaded file. If the received data does not match it, the download
+// fails. For DownloadExtract the checksum is verified against the
+// compressed stream.
+func OptSHA256(sum string) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.sha256 = strings.ToLower(sum)
+	}
+}
+
+// OptExtract sets options of the extraction done by DownloadExtract, for
+// example filters, limits or the overwrite policy. Download ignores them.
+func OptExtract(opts ...ExtractOption) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.extractOpts = append(cfg.extractOpts, opts...)
+	}
+}
+
+// OptSSHKeyFiles sets private key files used to authenticate sftp://
+// downloads. By default ~/.ssh/id_ed25519, ~/.ssh/id_ecdsa and ~/.ssh/id_rsa
+// are tried, together with keys from a running ssh-agent.
+func OptSSHKeyFiles(paths ...string) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.sshKeyFiles = paths
+	}
+}
+
+// OptKnownHosts sets a known_hosts file used to verify the host key of an
+// sftp:// server. By default ~/.ssh/known_hosts is used.
+func OptKnownHosts(path string) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.knownHostsFile = path
+	}
+}
+
 // Ping checks if a server is reachable.
 // Host should be in format "host:port" (eg "google.com:80")
 func Ping(host string, seconds int) bool {
@@ -26,19 +114,31 @@ func Ping(host string, seconds int) bool {
 }
 
 // Download fetches a file from a URL and saves it to the specified directory.
-// It supports http://, https://, and file:// URL schemes.
+// It supports http://, https://, sftp://, s3:// and file:// URL schemes.
 //
 // Parameters:
 //   - rawURL: The source URL to download from. For local files, use file:// scheme
-//     (e.g., "file:///path/to/file.txt").
+//     (e.g., "file:///path/to/file.txt"). For SSH servers use sftp:// scheme
+//     (e.g., "sftp://user@host/path/to/file.txt"), for S3-compatible storage
+//     use s3:// scheme (e.g., "s3://bucket/path/to/file.txt").
 //   - destDir: The destination directory where the file will be saved.
 //     The filename is extracted from the URL path.
 //   - showProgress: When true, displays a progress bar during download.
 //     The progress bar clears itself upon completion.
+//   - opts: Optional settings, for example OptResume.
+//
+// Concurrent calls that download the same URL to the same destination
+// share one transfer: only the first call fetches the file (with its own
+// options), the others wait for it and receive the same result. A waiting
+// call with OptSHA256 verifies the received file against its own checksum.
 //
 // Returns the full path to the downloaded file and any error encountered.
 // On error, returns an empty string and an ErrDownload wrapping the underlying error.
-func Download(rawURL, destDir string, showProgress bool) (string, error) {
+func Download(
+	rawURL, destDir string,
+	showProgress bool,
+	opts ...DownloadOption,
+) (string, error) {
 	// Parse the URL to determine the scheme
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
@@ -49,72 +149,454 @@ func Download(rawURL, destDir string, showProgress bool) (string, error) {
 	filename := filepath.Base(parsedURL.Path)
 	destPath := filepath.Join(destDir, filename)
 
-	// Create the destination file
-	outFile, err := os.Create(destPath)
-	if err != nil {
-		return "", err
+	key := destPath
+	if abs, err := filepath.Abs(destPath); err == nil {
+		key = abs
 	}
-	defer outFile.Close()
+	key = rawURL + "\n" + key
 
-	var reader io.Reader
-	var contentLength int64
+	path, shared, err := downloads.do(key, func() (string, error) {
+		return download(rawURL, parsedURL, destPath, showProgress, opts)
+	})
+	if err != nil || !shared {
+		return path, err
+	}
 
-	switch parsedURL.Scheme {
-	case "file":
-		// Handle local file copy
-		srcPath := parsedURL.Path
-		srcFile, err := os.Open(srcPath)
-		if err != nil {
+	// The options of this call were not used for the shared transfer.
+	cfg := downloadConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.sha256 != "" {
+		if err = verifyFile(path, cfg.sha256); err != nil {
 			return "", &ErrDownload{URL: rawURL, Err: err}
 		}
-		defer srcFile.Close()
+	}
+	return path, nil
+}
 
-		// Get file size for progress bar
-		fileInfo, err := srcFile.Stat()
-		if err != nil {
-			return "", &ErrDownload{URL: rawURL, Err: err}
-		}
-		contentLength = fileInfo.Size()
-		reader = srcFile
+// download performs the transfer for Download.
+func download(
+	rawURL string,
+	parsedURL *url.URL,
+	destPath string,
+	showProgress bool,
+	opts []DownloadOption,
+) (string, error) {
+	cfg := downloadConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-	case "http", "https":
-		// Issue HTTP GET request
-		resp, err := http.Get(rawURL)
-		if err != nil {
-			return "", &ErrDownload{URL: rawURL, Err: err}
+	// Find out how much of the file is already downloaded.
+	var offset int64
+	if cfg.resume {
+		fi, err := os.Stat(destPath)
+		if err == nil && fi.Mode().IsRegular() {
+			offset = fi.Size()
 		}
-		defer resp.Body.Close()
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf(
-				"download failed: server returned status %d",
-				resp.StatusCode,
-			)
-			return "", &ErrDownload{URL: rawURL, Err: err}
-		}
+	src, err := openSource(parsedURL, offset, &cfg)
+	if err != nil {
+		return "", &ErrDownload{URL: rawURL, Err: err}
+	}
+	defer src.close()
 
-		contentLength = resp.ContentLength
-		reader = resp.Body
+	// Create the destination file, or append to it if resuming.
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if src.offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	outFile, err := os.OpenFile(destPath, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer outFile.Close()
 
-	default:
-		err = fmt.Errorf("unsupported URL scheme: %s", parsedURL.Scheme)
-		return "", &ErrDownload{URL: rawURL, Err: err}
+	var h hash.Hash
+	if cfg.sha256 != "" {
+		h = sha256.New()
+		// The checksum covers the part downloaded before resumption too.
+		if src.offset > 0 {
+			if err = hashFile(h, destPath, src.offset); err != nil {
+				return "", &ErrDownload{URL: rawURL, Err: err}
+			}
+		}
 	}
 
+	reader := src.reader
 	if showProgress {
 		// Create the progress bar
-		bar := pb.Full.Start64(contentLength)
+		bar := pb.Full.Start64(src.size)
 		bar.Set(pb.CleanOnFinish, true)
+		bar.SetCurrent(src.offset)
 		reader = bar.NewProxyReader(reader)
 
 		// Finish the progress bar
 		defer bar.Finish()
 	}
+	if h != nil {
+		reader = io.TeeReader(reader, h)
+	}
 
 	_, err = io.Copy(outFile, reader)
 	if err != nil {
 		return "", &ErrDownload{URL: rawURL, Err: err}
 	}
 
+	if h != nil {
+		if err = verifySHA256(h, cfg.sha256); err != nil {
+			outFile.Close()
+			os.Remove(destPath)
+			return "", &ErrDownload{URL: rawURL, Err: err}
+		}
+	}
+
 	return destPath, nil
 }
+
+// DownloadExtract fetches an archive or a compressed file from a URL and
+// extracts it into dstDir on the fly, without saving the downloaded file
+// to disk. Supported URL schemes are the same as for Download.
+//
+// The format is detected from the beginning of the stream (see
+// DetectFileType). If it is not recognized, the file name in the URL is
+// used, and, if the name has no known extension, the Content-Type of
+// an HTTP response.
+// Tar archives (plain, gz, bz2, xz, zst, lz4, Z) are unpacked while they
+// stream in, compressed single files are saved without the compression
+// extension.
+// Zip archives require random access, so they are temporarily saved to
+// dstDir (or next to it for atomic extraction) and removed after
+// extraction.
+//
+// Extraction is configured with OptExtract.
+//
+// If OptSHA256 is given, the checksum is calculated over the compressed
+// stream, and the extraction is atomic (see OptAtomic): extracted files are
+// moved to dstDir only if the checksum matches. On mismatch nothing is
+// left in dstDir.
+//
+// Problems with fetching data or checksum are returned as ErrDownload,
+// problems with the archive itself as ErrExtract.
+func DownloadExtract(
+	rawURL, dstDir string,
+	showProgress bool,
+	opts ...DownloadOption,
+) error {
+	cfg := downloadConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return &ErrDownload{URL: rawURL, Err: err}
+	}
+	name := filepath.Base(parsedURL.Path)
+
+	src, err := openSource(parsedURL, 0, &cfg)
+	if err != nil {
+		return &ErrDownload{URL: rawURL, Err: err}
+	}
+	defer src.close()
+
+	reader := src.reader
+	if showProgress {
+		bar := pb.Full.Start64(src.size)
+		bar.Set(pb.CleanOnFinish, true)
+		reader = bar.NewProxyReader(reader)
+		defer bar.Finish()
+	}
+
+	var h hash.Hash
+	if cfg.sha256 != "" {
+		h = sha256.New()
+		reader = io.TeeReader(reader, h)
+	}
+
+	br := bufio.NewReaderSize(reader, sniffSize)
+	ft, err := detectFileType(br)
+	if err != nil {
+		return &ErrDownload{URL: rawURL, Err: err}
+	}
+	if ft == UnknownFT || ft == TextFT {
+		ft = GetFileType(name)
+	}
+	if ft == UnknownFT {
+		ft = contentFileType(src.contentType)
+	}
+
+	extractOpts := cfg.extractOpts
+	if h != nil {
+		verify := func() error {
+			// Archive readers might stop before the end of the stream
+			// (e.g. tar padding), the checksum needs all of it.
+			if _, err := io.Copy(io.Discard, br); err != nil {
+				return &ErrDownload{URL: rawURL, Err: err}
+			}
+			if err := verifySHA256(h, cfg.sha256); err != nil {
+				return &ErrDownload{URL: rawURL, Err: err}
+			}
+			return nil
+		}
+		extractOpts = append(slices.Clip(extractOpts), OptAtomic(true),
+			func(cfg *extractConfig) { cfg.verify = verify })
+	}
+	return extractStream(br, ft, name, dstDir, extractOpts)
+}
+
+// contentFileType guesses file type from Content-Type header.
+func contentFileType(contentType string) FileType {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "application/zip", "application/x-zip-compressed":
+		return ZipFT
+	case "application/x-tar":
+		return TarFT
+	case "application/x-gtar", "application/x-tgz":
+		return TarGzFT
+	case "application/gzip", "application/x-gzip":
+		return GzFT
+	case "application/x-bzip2":
+		return Bz2FT
+	case "application/x-xz":
+		return XzFT
+	case "application/zstd":
+		return ZstFT
+	case "application/x-lz4":
+		return Lz4FT
+	case "application/x-compress":
+		return ZFT
+	default:
+		return UnknownFT
+	}
+}
+
+// hashFile adds the first n bytes of a file to the hash.
+func hashFile(h hash.Hash, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(h, f, n)
+	return err
+}
+
+// verifyFile compares the SHA-256 checksum of a file with the expected
+// hex-encoded one.
+func verifyFile(path, expected string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if err = hashFile(h, path, fi.Size()); err != nil {
+		return err
+	}
+	return verifySHA256(h, expected)
+}
+
+// verifySHA256 compares the hash sum with the expected hex-encoded one.
+func verifySHA256(h hash.Hash, expected string) error {
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != expected {
+		return fmt.Errorf(
+			"checksum mismatch: expected sha256 %s, got %s", expected, sum,
+		)
+	}
+	return nil
+}
+
+// downloads coalesces concurrent downloads of the same URL into the same
+// destination.
+var downloads = &flightGroup{calls: make(map[string]*flightCall)}
+
+// flightCall is a download in progress or completed.
+type flightCall struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+// flightGroup makes sure that only one call with a given key runs at
+// a time, while duplicate calls wait for its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do runs fn, unless a call with the same key is already running. In that
+// case it waits for the running call and returns its results with shared
+// set to true.
+func (g *flightGroup) do(
+	key string,
+	fn func() (string, error),
+) (path string, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.path, true, c.err
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.path, c.err = fn()
+	return c.path, false, c.err
+}
+
+// source is an opened stream of remote data.
+type source struct {
+	// reader provides data starting from the offset.
+	reader io.Reader
+
+	// size is the full size of the remote file, -1 if unknown.
+	size int64
+
+	// offset is the position in the remote file the reader starts at.
+	// It is 0 unless a resumed download was requested and is possible.
+	offset int64
+
+	// contentType is the media type reported by the server, if any.
+	contentType string
+
+	// close releases resources associated with the source.
+	close func() error
+}
+
+// openSource opens a URL for reading, skipping the first offset bytes
+// if the scheme allows it.
+func openSource(
+	u *url.URL,
+	offset int64,
+	cfg *downloadConfig,
+) (*source, error) {
+	switch u.Scheme {
+	case "file":
+		return openFileSource(u.Path, offset)
+	case "http", "https":
+		return openHTTPSource(u.String(), offset)
+	case "sftp":
+		return openSFTPSource(u, offset, cfg)
+	case "s3":
+		return openS3Source(u, offset, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+	}
+}
+
+// openFileSource handles local file copy.
+func openFileSource(path string, offset int64) (*source, error) {
+	srcFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get file size for progress bar
+	fileInfo, err := srcFile.Stat()
+	if err != nil {
+		srcFile.Close()
+		return nil, err
+	}
+	size := fileInfo.Size()
+
+	if offset > size {
+		offset = 0
+	}
+	if _, err = srcFile.Seek(offset, io.SeekStart); err != nil {
+		srcFile.Close()
+		return nil, err
+	}
+
+	res := &source{
+		reader: srcFile,
+		size:   size,
+		offset: offset,
+		close:  srcFile.Close,
+	}
+	return res, nil
+}
+
+// openHTTPSource issues HTTP GET request. If offset is not zero, it asks
+// the server for the rest of the file using Range header.
+func openHTTPSource(rawURL string, offset int64) (*source, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, the download starts from scratch.
+		offset = 0
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+	case offset > 0 &&
+		resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The range is not satisfiable if the file is already complete.
+		resp.Body.Close()
+		size, ok := contentRangeSize(resp.Header.Get("Content-Range"))
+		if ok && size == offset {
+			res := &source{
+				reader: strings.NewReader(""),
+				size:   size,
+				offset: offset,
+				close:  func() error { return nil },
+			}
+			return res, nil
+		}
+		return openHTTPSource(rawURL, 0)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf(
+			"download failed: server returned status %d",
+			resp.StatusCode,
+		)
+	}
+
+	size := resp.ContentLength
+	if size >= 0 {
+		size += offset
+	}
+
+	res := &source{
+		reader:      resp.Body,
+		size:        size,
+		offset:      offset,
+		contentType: resp.Header.Get("Content-Type"),
+		close:       resp.Body.Close,
+	}
+	return res, nil
+}
+
+// contentRangeSize returns the complete length of a resource from
+// Content-Range header of the "bytes */1234" form.
+func contentRangeSize(header string) (int64, bool) {
+	_, size, ok := strings.Cut(header, "/")
+	if !ok {
+		return 0, false
+	}
+	res, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return res, true
+}
@@ -36,15 +36,63 @@ func (e *ErrNotDir) Error() string {
 // ErrExtract is returned when the extraction of a file (e.g., Zip, Tar file)
 // fails. The Path field specifies the file that was being extracted, and the
 // Err field contains the underlying error that caused the extraction to fail.
+// If the failure is caused by a particular archive entry, the Entry field
+// contains its name.
 type ErrExtract struct {
-	Path string
-	Err  error
+	Path  string
+	Entry string
+	Err   error
 }
 
 func (e *ErrExtract) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf(
+			"extracting '%s' failed on entry '%s': %v", e.Path, e.Entry, e.Err,
+		)
+	}
 	return fmt.Sprintf("extracting '%s' failed: %v", e.Path, e.Err)
 }
 
+// ErrArchive is returned when the creation of an archive or a compressed
+// file fails. The Path field specifies the file that was being created,
+// and the Err field contains the underlying error. If the failure is caused
+// by a particular file, the Entry field contains its name in the archive.
+type ErrArchive struct {
+	Path  string
+	Entry string
+	Err   error
+}
+
+func (e *ErrArchive) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf(
+			"creating '%s' failed on entry '%s': %v", e.Path, e.Entry, e.Err,
+		)
+	}
+	return fmt.Sprintf("creating '%s' failed: %v", e.Path, e.Err)
+}
+
+// ErrLimit is returned when extraction exceeds one of the limits set by
+// OptMaxTotalSize, OptMaxEntrySize, OptMaxEntries or OptMaxRatio, which
+// is a sign of a decompression bomb. The Limit field names the exceeded
+// limit ("total size", "entry size", "entries" or "compression ratio"),
+// the Entry field contains the name of the archive entry, if there is one.
+type ErrLimit struct {
+	Path  string
+	Entry string
+	Limit string
+}
+
+func (e *ErrLimit) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf(
+			"extracting '%s' stopped on entry '%s': %s limit exceeded",
+			e.Path, e.Entry, e.Limit,
+		)
+	}
+	return fmt.Sprintf("extracting '%s' stopped: %s limit exceeded", e.Path, e.Limit)
+}
+
 // ErrDownload is returned when a file download operation fails. The URL field
 // specifies the URL that was being downloaded, and the Err field contains the
 // underlying error that caused the download to fail.
@@ -56,3 +104,25 @@ type ErrDownload struct {
 func (e *ErrDownload) Error() string {
 	return fmt.Sprintf("cannot download file: %s", e.Err)
 }
+
+// ErrUpload is returned when a file upload operation fails. The URL field
+// specifies the URL the file was uploaded to, and the Err field contains the
+// underlying error that caused the upload to fail.
+type ErrUpload struct {
+	URL string
+	Err error
+}
+
+func (e *ErrUpload) Error() string {
+	return fmt.Sprintf("cannot upload file: %s", e.Err)
+}
+
+// ErrNoExtractor is returned when there is no extractor for a file type,
+// for example because the file is not an archive or is of unknown type.
+type ErrNoExtractor struct {
+	FileType FileType
+}
+
+func (e *ErrNoExtractor) Error() string {
+	return fmt.Sprintf("no extractor for file type '%s'", e.FileType)
+}
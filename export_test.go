@@ -0,0 +1,15 @@
+package gnsys
+
+import (
+	"net/http"
+	"time"
+)
+
+// SignS3 signs a request with AWS Signature Version 4 for tests.
+func SignS3(req *http.Request, t time.Time, region, accessKey, secretKey string) {
+	c := &s3Client{
+		region: region,
+		creds:  s3Credentials{accessKey: accessKey, secretKey: secretKey},
+	}
+	c.sign(req, t)
+}
@@ -3,21 +3,343 @@ package gnsys
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"compress/bzip2"
 	"compress/gzip"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/ulikunitz/xz"
 )
 
-type Extractor func(src, dst string) error
+// Extractor is a function that extracts the file at src into the
+// directory dst.
+type Extractor func(src, dst string, opts ...ExtractOption) error
+
+// ExtractOption is a function that configures extraction.
+type ExtractOption func(*extractConfig)
+
+// extractConfig keeps settings that modify behavior of extraction.
+type extractConfig struct {
+	// unsupported determines what happens to archive entries of types
+	// that cannot be extracted.
+	unsupported UnsupportedPolicy
+
+	// skipAttrs disables restoring of permissions and modification times.
+	skipAttrs bool
+
+	// umask is removed from permissions of extracted files and directories.
+	umask os.FileMode
+
+	// filter selects entries of archives to extract.
+	filter entryFilter
+
+	// strip is the number of leading path components removed from names
+	// of entries.
+	strip int
+
+	// rename maps names of entries to new names.
+	rename func(string) string
+
+	// limits protect from decompression bombs.
+	limits limits
+
+	// progress enables a progress bar.
+	progress bool
+
+	// workers is the number of concurrent workers of zip extraction.
+	workers int
+
+	// atomic enables extraction through a staging directory.
+	atomic bool
+
+	// overwrite determines what happens to existing files.
+	overwrite OverwritePolicy
+
+	// report receives entries skipped or renamed by the overwrite policy.
+	report *ExtractReport
+
+	// verify is called before the result of an atomic extraction is moved
+	// into place. Its error stops the extraction.
+	verify func() error
+}
+
+// check runs the verification of the result, if there is one.
+func (cfg *extractConfig) check() error {
+	if cfg.verify == nil {
+		return nil
+	}
+	return cfg.verify()
+}
+
+// newExtractConfig creates a configuration with default settings modified
+// by options.
+func newExtractConfig(opts []ExtractOption) extractConfig {
+	res := extractConfig{umask: 0o022}
+	for _, opt := range opts {
+		opt(&res)
+	}
+	return res
+}
+
+// UnsupportedPolicy determines what extraction does with archive entries
+// of types it does not support, such as devices or FIFOs.
+type UnsupportedPolicy int
+
+const (
+	// FailUnsupported stops extraction with an ErrExtract. It is the default.
+	FailUnsupported UnsupportedPolicy = iota
+
+	// SkipUnsupported silently ignores such entries.
+	SkipUnsupported
+
+	// WarnUnsupported ignores such entries, logging a warning for each.
+	WarnUnsupported
+)
+
+// OptUnsupported sets the policy for archive entries of unsupported types.
+func OptUnsupported(p UnsupportedPolicy) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.unsupported = p
+	}
+}
+
+// OptPreserveAttrs sets whether extraction restores permissions and
+// modification/access times of files and directories recorded in the
+// archive. It is enabled by default. When disabled, files get default
+// permissions and the time of extraction.
+func OptPreserveAttrs(b bool) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.skipAttrs = !b
+	}
+}
+
+// OptUmask sets permission bits that are removed from modes restored from
+// the archive. The default is 022. Setuid, setgid and sticky bits are never
+// restored.
+func OptUmask(mask os.FileMode) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.umask = mask.Perm()
+	}
+}
+
+// OptInclude limits extraction to archive entries matching at least one
+// of the glob patterns (see path.Match). A pattern is matched against the
+// slash-separated name of an entry and against its base name. If a pattern
+// matches a directory, all its content is included. Include options,
+// including OptIncludeRegexp and OptEntries, add to each other.
+// Filters apply to archive entries only, not to single compressed files.
+func OptInclude(patterns ...string) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.filter.include = append(cfg.filter.include, patterns...)
+	}
+}
+
+// OptExclude skips archive entries matching any of the glob patterns.
+// Patterns are matched the same way as in OptInclude. Exclusion takes
+// precedence over inclusion.
+func OptExclude(patterns ...string) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.filter.exclude = append(cfg.filter.exclude, patterns...)
+	}
+}
+
+// OptIncludeRegexp limits extraction to archive entries whose
+// slash-separated names match at least one of the regular expressions.
+func OptIncludeRegexp(res ...*regexp.Regexp) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.filter.includeRe = append(cfg.filter.includeRe, res...)
+	}
+}
+
+// OptExcludeRegexp skips archive entries whose slash-separated names match
+// any of the regular expressions.
+func OptExcludeRegexp(res ...*regexp.Regexp) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.filter.excludeRe = append(cfg.filter.excludeRe, res...)
+	}
+}
+
+// OptEntries limits extraction to the archive entries with the given names
+// (e.g. "dump/names.csv"). If no include patterns are given, reading of
+// a tar archive stops as soon as all the entries are found. If some of them
+// are not in the archive, extraction returns an error.
+func OptEntries(names ...string) ExtractOption {
+	return func(cfg *extractConfig) {
+		if cfg.filter.entries == nil {
+			cfg.filter.entries = make(map[string]bool)
+		}
+		for _, v := range names {
+			cfg.filter.entries[cleanEntryName(v)] = true
+		}
+	}
+}
+
+// OptStripComponents removes n leading components from paths of archive
+// entries, like the --strip-components option of tar. It allows to get rid
+// of a versioned top directory, e.g. "dataset-2026-10/names.csv" is
+// extracted as "names.csv" with n = 1. Entries with n or fewer components
+// are skipped.
+func OptStripComponents(n int) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.strip = n
+	}
+}
+
+// OptRename sets a function that maps slash-separated names of archive
+// entries to new relative paths in the destination directory. It is called
+// after OptStripComponents is applied, and the entry is skipped if it
+// returns an empty string. Returned paths are subject to the same checks as
+// names from the archive, so they cannot lead outside of the destination.
+// Filters (OptInclude etc.) match names as they are stored in the archive.
+func OptRename(fn func(name string) string) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.rename = fn
+	}
+}
+
+// OptMaxTotalSize limits the total size of extracted data in bytes.
+// Limits protect from decompression bombs: if one of them is exceeded,
+// extraction stops, removes the files it has created and returns ErrLimit.
+// Files that existed before are kept, even if they were overwritten. Only
+// entries selected for extraction count. Zero means no limit, which is
+// the default for all limits.
+func OptMaxTotalSize(n int64) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.limits.maxTotal = n
+	}
+}
+
+// OptMaxEntrySize limits the size of one extracted file in bytes. It also
+// applies to the result of single-file extractors (ExtractGz etc.).
+func OptMaxEntrySize(n int64) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.limits.maxEntry = n
+	}
+}
+
+// OptMaxEntries limits the number of extracted entries of an archive.
+func OptMaxEntries(n int) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.limits.maxEntries = n
+	}
+}
+
+// OptMaxRatio limits the ratio of uncompressed to compressed size. It is
+// checked for every zip entry, and for the whole stream of compressed tar
+// archives and single compressed files. The ratio is checked only after
+// the first MiB of uncompressed data, because small files of repetitive
+// content compress extremely well.
+func OptMaxRatio(r float64) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.limits.maxRatio = r
+	}
+}
+
+// OptProgress shows a progress bar of extraction, styled like the one of
+// Download. The bar tracks compressed data consumed from the source, and
+// counts processed entries of archives.
+func OptProgress(b bool) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.progress = b
+	}
+}
+
+// OptWorkers extracts entries of zip archives with n concurrent workers.
+// Directories are created first, then workers write files, each keeping
+// one entry and one output file open at a time, so at most 2*n file
+// descriptors are used besides the archive. Values below 2 mean serial
+// extraction, which is the default. Tar archives and compressed streams
+// are read sequentially, so they are not affected.
+func OptWorkers(n int) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.workers = n
+	}
+}
+
+// OptAtomic makes extraction all or nothing. Archives are extracted into
+// a hidden staging directory next to dstDir, and its content is moved to
+// dstDir only after complete success. Extracted files are merged into
+// dstDir like without the option: every file is renamed into place
+// atomically, other files of dstDir are kept, and the overwrite policy
+// applies to the files of dstDir. Files of dstDir in the place of extracted
+// directories (symbolic links included), and directories in the place of
+// extracted files, fail the extraction before anything is moved.
+// Single compressed files are always written to a temporary file that is
+// renamed to the result. On failure the staging directory or the temporary
+// file is removed, and dstDir stays untouched.
+func OptAtomic(b bool) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.atomic = b
+	}
+}
+
+// extractors maps file types to their extractors.
+var extractors = map[FileType]Extractor{
+	ZipFT:    ExtractZip,
+	GzFT:     ExtractGz,
+	Bz2FT:    ExtractBz2,
+	XzFT:     ExtractXz,
+	TarFT:    ExtractTar,
+	TarGzFT:  ExtractTarGz,
+	TarBzFT:  ExtractTarBz2,
+	TarXzFt:  ExtractTarXz,
+	ZstFT:    ExtractZst,
+	TarZstFT: ExtractTarZst,
+	Lz4FT:    ExtractLz4,
+	TarLz4FT: ExtractTarLz4,
+	BrFT:     ExtractBr,
+	ZFT:      ExtractZ,
+	TarZFT:   ExtractTarZ,
+}
+
+// ExtractorFor returns the extractor for a file type. For types that are
+// not archives or compressed files (e.g. SqlFT, SqliteFT, UnknownFT) it
+// returns ErrNoExtractor.
+func ExtractorFor(ft FileType) (Extractor, error) {
+	res, ok := extractors[ft]
+	if !ok {
+		return nil, &ErrNoExtractor{FileType: ft}
+	}
+	return res, nil
+}
+
+// Extract extracts the file located at srcPath to the destination directory
+// dstDir, choosing the extractor by the file type. The type is detected
+// from the content of the file (see DetectFileType), the file name is used
+// only if the content is not recognized (see GetFileType).
+// If the type cannot be extracted, it returns ErrNoExtractor.
+func Extract(srcPath, dstDir string, opts ...ExtractOption) error {
+	ft, err := DetectFileType(srcPath)
+	if err != nil {
+		return &ErrExtract{Path: srcPath, Err: err}
+	}
+	if ft == UnknownFT {
+		ft = GetFileType(srcPath)
+	}
+
+	extract, err := ExtractorFor(ft)
+	if err != nil {
+		return err
+	}
+	return extract(srcPath, dstDir, opts...)
+}
 
 // ExtractZip extracts a zip archive located at srcPath to the destination
 // directory dstDir.
-func ExtractZip(srcPath, dstDir string) error {
+func ExtractZip(srcPath, dstDir string, opts ...ExtractOption) error {
 	exists, _ := FileExists(srcPath)
 	if !exists {
 		return &ErrFileMissing{Path: srcPath}
@@ -26,275 +348,1390 @@ func ExtractZip(srcPath, dstDir string) error {
 	// Open the zip file for reading.
 	r, err := zip.OpenReader(srcPath)
 	if err != nil {
-		return &ErrExtract{Path: srcPath, Err: err}
+		return &ErrExtract{Path: srcPath, Err: err}
+	}
+	defer r.Close()
+
+	return extractZip(&r.Reader, srcPath, dstDir, opts)
+}
+
+// ExtractZipReader extracts a zip archive of the given size read from r
+// to the destination directory dstDir. Zip archives keep their index at
+// the end, so they need random access instead of a stream.
+// Errors refer to dstDir, because there is no source path.
+func ExtractZipReader(
+	r io.ReaderAt,
+	size int64,
+	dstDir string,
+	opts ...ExtractOption,
+) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return &ErrExtract{Path: dstDir, Err: err}
+	}
+	return extractZip(zr, dstDir, dstDir, opts)
+}
+
+// extractZip extracts all files of a zip archive. The src is the archive
+// path or name used in error messages.
+func extractZip(
+	r *zip.Reader,
+	src, dstDir string,
+	opts []ExtractOption,
+) error {
+	// Validate all entries before writing anything.
+	var err error
+	names := make([]string, len(r.File))
+	for i, f := range r.File {
+		names[i], err = entryName(f.Name)
+		if err != nil {
+			return &ErrExtract{Path: src, Entry: f.Name, Err: err}
+		}
+	}
+
+	e, err := newExtraction(src, dstDir, opts)
+	if err != nil {
+		return err
+	}
+	defer e.close()
+
+	err = e.checkZip(r, names)
+	if err == nil {
+		var size int64
+		for _, f := range r.File {
+			size += int64(f.CompressedSize64)
+		}
+		e.progress = newProgress(&e.cfg, size)
+		defer e.progress.finish()
+		err = e.unzip(r, names)
+	}
+	if err == nil {
+		err = e.finish()
+	}
+	if err != nil {
+		e.abort(err)
+		return err
+	}
+	return e.commit()
+}
+
+// checkZip selects entries of a zip archive and verifies their sizes
+// declared in the central directory before anything is written. Names of
+// selected entries are replaced with the names they are extracted under,
+// names of the rest with empty strings.
+func (e *extraction) checkZip(r *zip.Reader, names []string) error {
+	var total int64
+	for i, f := range r.File {
+		name, ok, err := e.keeps(names[i])
+		if err != nil {
+			return e.entryErr(f.Name, err)
+		}
+		if !ok {
+			names[i] = ""
+			continue
+		}
+		names[i] = name
+
+		if err := e.limits.addEntry(f.Name); err != nil {
+			return err
+		}
+		size, packed := int64(f.UncompressedSize64), int64(f.CompressedSize64)
+		if err := e.limits.checkDeclared(f.Name, size, packed); err != nil {
+			return err
+		}
+		total += size
+		if e.cfg.limits.maxTotal > 0 && total > e.cfg.limits.maxTotal {
+			return e.limits.err("", "total size")
+		}
+	}
+	return nil
+}
+
+// unzip extracts files of a zip archive selected by checkZip.
+func (e *extraction) unzip(r *zip.Reader, names []string) error {
+	if e.cfg.workers > 1 {
+		return e.unzipParallel(r, names)
+	}
+	for i, f := range r.File {
+		// Skipped entries count as processed too.
+		e.progress.entry()
+		e.progress.add(int64(f.CompressedSize64))
+		if names[i] == "" {
+			continue
+		}
+		if err := e.zipEntry(f, names[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractGz extracts a gz compressed file located at srcPath to the
+// destination directory dstDir. The modification time stored in the gzip
+// header is restored unless OptPreserveAttrs(false) is given.
+func ExtractGz(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, GzFT, opts)
+}
+
+// ExtractBz2 extracts a bz2 compressed file located at srcPath to the
+// destination directory dstDir.
+func ExtractBz2(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, Bz2FT, opts)
+}
+
+// ExtractXz extracts an xz compressed file located at srcPath to the
+// destination directory dstDir.
+func ExtractXz(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, XzFT, opts)
+}
+
+// ExtractZst extracts a zstd compressed file located at srcPath to the
+// destination directory dstDir.
+func ExtractZst(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, ZstFT, opts)
+}
+
+// ExtractLz4 extracts an lz4 (frame format) compressed file located at
+// srcPath to the destination directory dstDir.
+func ExtractLz4(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, Lz4FT, opts)
+}
+
+// ExtractBr extracts a brotli compressed file located at srcPath to the
+// destination directory dstDir.
+func ExtractBr(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, BrFT, opts)
+}
+
+// ExtractZ extracts a file compressed by Unix compress (.Z) located at
+// srcPath to the destination directory dstDir.
+func ExtractZ(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractFile(srcPath, dstDir, ZFT, opts)
+}
+
+// ExtractTar extracts a tar archive located at srcPath to the destination
+// directory dstDir.
+func ExtractTar(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarFT, opts)
+}
+
+// ExtractTarGz extracts a tar.gz archive located at srcPath to the destination
+// directory dstDir.
+func ExtractTarGz(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarGzFT, opts)
+}
+
+// ExtractTarBz2 extracts a tar.bz2 archive located at srcPath to the destination
+// directory dstDir.
+func ExtractTarBz2(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarBzFT, opts)
+}
+
+// ExtractTarXz extracts a tar.xz archive located at srcPath to the destination
+// directory dstDir.
+func ExtractTarXz(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarXzFt, opts)
+}
+
+// ExtractTarZst extracts a tar.zst archive located at srcPath to the
+// destination directory dstDir.
+func ExtractTarZst(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarZstFT, opts)
+}
+
+// ExtractTarLz4 extracts a tar.lz4 archive located at srcPath to the
+// destination directory dstDir.
+func ExtractTarLz4(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarLz4FT, opts)
+}
+
+// ExtractTarZ extracts a tar.Z archive located at srcPath to the
+// destination directory dstDir.
+func ExtractTarZ(srcPath, dstDir string, opts ...ExtractOption) error {
+	return extractTarFile(srcPath, dstDir, TarZFT, opts)
+}
+
+// ExtractReader extracts an archive or a compressed file read from r to
+// the destination directory dstDir. The format is detected from the
+// beginning of the data (see DetectFileType), or, if it is not recognized,
+// from the name (see GetFileType). The name is also used in error messages
+// and, without the compression extension, as the name of a decompressed
+// single file (a name without extension gets ".out" appended).
+// Tar archives are unpacked while they stream in. Zip archives need random
+// access, so if r is not an io.ReaderAt with an io.Seeker (like *os.File),
+// they are temporarily saved to dstDir.
+func ExtractReader(
+	r io.Reader,
+	name, dstDir string,
+	opts ...ExtractOption,
+) error {
+	// Remember the position and size before any data is buffered.
+	section := readerSection(r)
+	size := streamSize(r)
+
+	br := bufio.NewReaderSize(r, sniffSize)
+	ft, err := detectFileType(br)
+	if err != nil {
+		return &ErrExtract{Path: name, Err: err}
+	}
+	if ft == UnknownFT || ft == TextFT {
+		ft = GetFileType(name)
+	}
+
+	// Buffering hides the size of the source from the progress bar.
+	var src io.Reader = br
+	if size > 0 {
+		src = &sizedReader{Reader: br, size: size}
+	}
+
+	if ft == ZipFT && section != nil {
+		zr, err := zip.NewReader(section, section.Size())
+		if err != nil {
+			return &ErrExtract{Path: name, Err: err}
+		}
+		return extractZip(zr, name, dstDir, opts)
+	}
+	if _, err = ExtractorFor(ft); err != nil {
+		return err
+	}
+	return extractStream(src, ft, name, dstDir, opts)
+}
+
+// readerSection returns a section from the current position to the end
+// of a reader that supports random access, or nil for other readers.
+func readerSection(r io.Reader) *io.SectionReader {
+	rs, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil
+	}
+	if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return nil
+	}
+	return io.NewSectionReader(rs, start, end-start)
+}
+
+// ExtractTarReader extracts a tar archive read from r to the destination
+// directory dstDir. Errors refer to dstDir, because there is no source path.
+func ExtractTarReader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarFT, dstDir, dstDir, opts)
+}
+
+// ExtractTarGzReader extracts a tar.gz archive read from r to the
+// destination directory dstDir.
+func ExtractTarGzReader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarGzFT, dstDir, dstDir, opts)
+}
+
+// ExtractTarBz2Reader extracts a tar.bz2 archive read from r to the
+// destination directory dstDir.
+func ExtractTarBz2Reader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarBzFT, dstDir, dstDir, opts)
+}
+
+// ExtractTarXzReader extracts a tar.xz archive read from r to the
+// destination directory dstDir.
+func ExtractTarXzReader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarXzFt, dstDir, dstDir, opts)
+}
+
+// ExtractTarZstReader extracts a tar.zst archive read from r to the
+// destination directory dstDir.
+func ExtractTarZstReader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarZstFT, dstDir, dstDir, opts)
+}
+
+// ExtractTarLz4Reader extracts a tar.lz4 archive read from r to the
+// destination directory dstDir.
+func ExtractTarLz4Reader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarLz4FT, dstDir, dstDir, opts)
+}
+
+// ExtractTarZReader extracts a tar.Z archive read from r to the
+// destination directory dstDir.
+func ExtractTarZReader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extractTarStream(r, TarZFT, dstDir, dstDir, opts)
+}
+
+// ExtractGzReader decompresses gzip data read from r into the file dstPath.
+// A stream has no file name to derive the result from, so the caller
+// provides the whole path. The modification time stored in the gzip header
+// is restored unless OptPreserveAttrs(false) is given.
+func ExtractGzReader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, GzFT, dstPath, dstPath, opts)
+}
+
+// ExtractBz2Reader decompresses bzip2 data read from r into the file dstPath.
+func ExtractBz2Reader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, Bz2FT, dstPath, dstPath, opts)
+}
+
+// ExtractXzReader decompresses xz data read from r into the file dstPath.
+func ExtractXzReader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, XzFT, dstPath, dstPath, opts)
+}
+
+// ExtractZstReader decompresses zstd data read from r into the file dstPath.
+func ExtractZstReader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, ZstFT, dstPath, dstPath, opts)
+}
+
+// ExtractLz4Reader decompresses lz4 data read from r into the file dstPath.
+func ExtractLz4Reader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, Lz4FT, dstPath, dstPath, opts)
+}
+
+// ExtractBrReader decompresses brotli data read from r into the file dstPath.
+func ExtractBrReader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, BrFT, dstPath, dstPath, opts)
+}
+
+// ExtractZReader decompresses Unix compress (.Z) data read from r into the
+// file dstPath.
+func ExtractZReader(r io.Reader, dstPath string, opts ...ExtractOption) error {
+	return decompressStream(r, ZFT, dstPath, dstPath, opts)
+}
+
+// extractFile decompresses a single compressed file into dstDir. The name
+// of the result is the name of the source without the compression extension
+// (see decompressedName).
+func extractFile(
+	srcPath, dstDir string,
+	ft FileType,
+	opts []ExtractOption,
+) error {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return &ErrExtract{Path: srcPath, Err: err}
+	}
+	defer file.Close()
+
+	dstPath := filepath.Join(dstDir, decompressedName(srcPath))
+	return decompressStream(file, ft, srcPath, dstPath, opts)
+}
+
+// decompressedName returns the name of the result of decompression: the
+// base name of the compressed file without its extension. Files without
+// an extension get ".out" appended instead, so the result never takes
+// the name of its source.
+func decompressedName(name string) string {
+	base := filepath.Base(name)
+	res := strings.TrimSuffix(base, filepath.Ext(base))
+	if res == "" || res == base {
+		return base + ".out"
+	}
+	return res
+}
+
+// writeDecompressed copies the decompressed content into dstPath. It writes
+// into a temporary file that is renamed to dstPath at the end, so a file
+// that existed at dstPath is kept if the extraction fails, for example
+// because a limit is exceeded.
+func writeDecompressed(
+	r io.Reader,
+	dstPath string,
+	cfg extractConfig,
+	limits *limiter,
+) error {
+	err := os.MkdirAll(filepath.Dir(dstPath), 0755)
+	if err != nil {
+		return &ErrExtract{Path: dstPath, Err: err}
+	}
+
+	// Apply the overwrite policy to an existing result.
+	if fi, err := os.Lstat(dstPath); err == nil &&
+		cfg.overwrite != OverwriteExisting {
+		var mtime time.Time
+		if gz, ok := r.(*gzip.Reader); ok {
+			mtime = gz.ModTime
+		}
+		exists := func(name string) bool {
+			_, err := os.Lstat(name)
+			return !os.IsNotExist(err)
+		}
+		path, err := resolveExisting(&cfg, dstPath, dstPath, fi, mtime, exists)
+		if err != nil {
+			return &ErrExtract{Path: dstPath, Err: err}
+		}
+		if path == "" {
+			return cfg.check()
+		}
+		dstPath = path
+	}
+
+	// Create a temporary file next to the destination.
+	dstFile, err := os.CreateTemp(
+		filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".gnsys-*",
+	)
+	if err == nil {
+		err = dstFile.Chmod(0644)
+	}
+	if err != nil {
+		if dstFile != nil {
+			dstFile.Close()
+			os.Remove(dstFile.Name())
+		}
+		return &ErrExtract{Path: dstPath, Err: err}
+	}
+	defer dstFile.Close()
+
+	// Removing is harmless after the file is renamed.
+	path := dstFile.Name()
+	defer os.Remove(path)
+
+	// Copy the file contents from the decompressing reader.
+	if _, err := io.Copy(limits.writer(dstFile, "", 0), r); err != nil {
+		var errLimit *ErrLimit
+		if errors.As(err, &errLimit) {
+			return err
+		}
+		return &ErrExtract{Path: dstPath, Err: err}
+	}
+
+	if err = dstFile.Close(); err != nil {
+		return &ErrExtract{Path: dstPath, Err: err}
+	}
+
+	// Gzip header keeps the modification time of the original file.
+	if gz, ok := r.(*gzip.Reader); ok && !cfg.skipAttrs &&
+		!gz.ModTime.IsZero() {
+		if err = os.Chtimes(path, gz.ModTime, gz.ModTime); err != nil {
+			return &ErrExtract{Path: dstPath, Err: err}
+		}
+	}
+
+	if err = cfg.check(); err != nil {
+		return err
+	}
+	if err = os.Rename(path, dstPath); err != nil {
+		return &ErrExtract{Path: dstPath, Err: err}
+	}
+	return nil
+}
+
+// extractTarFile extracts a tar archive, possibly compressed, located at
+// srcPath to the destination directory dstDir.
+func extractTarFile(
+	srcPath, dstDir string,
+	ft FileType,
+	opts []ExtractOption,
+) error {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return &ErrExtract{Path: srcPath, Err: err}
+	}
+	defer file.Close()
+
+	return extractTarStream(file, ft, srcPath, dstDir, opts)
+}
+
+// openDecompressor opens a compressed file of the given type and returns
+// a reader for its decompressed content. The caller must call the returned
+// cleanup function to close resources.
+func openDecompressor(srcPath string, ft FileType) (io.Reader, func(), error) {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+	}
+	rc, err := newDecompressor(file, ft)
+	if err != nil {
+		file.Close()
+		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+	}
+	return rc, func() { rc.Close(); file.Close() }, nil
+}
+
+// newDecompressor wraps a reader of compressed data of the given file type
+// into a reader of decompressed data. Plain tar data is returned as is.
+func newDecompressor(r io.Reader, ft FileType) (io.ReadCloser, error) {
+	switch ft {
+	case GzFT, TarGzFT:
+		return gzip.NewReader(r)
+	case Bz2FT, TarBzFT:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case XzFT, TarXzFt:
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case ZstFT, TarZstFT:
+		zstReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstReader.IOReadCloser(), nil
+	case Lz4FT, TarLz4FT:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	case BrFT:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case ZFT, TarZFT:
+		zReader, err := newZReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(zReader), nil
+	case TarFT:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("no decompressor for file type '%s'", ft)
+	}
+}
+
+// extractStream extracts data of a given file type read from r into dstDir.
+// Tar archives are unpacked on the fly, single compressed files are saved
+// under the name without the compression extension. Zip archives need
+// random access, so they are saved to a temporary file in dstDir first (or
+// next to it for atomic extraction).
+// The name is used for error messages and as a base for the output file name.
+func extractStream(
+	r io.Reader,
+	ft FileType,
+	name, dstDir string,
+	opts []ExtractOption,
+) error {
+	switch ft {
+	case ZipFT:
+		// Atomic extraction must not touch dstDir before it succeeds.
+		spoolDir := dstDir
+		if cfg := newExtractConfig(opts); cfg.atomic {
+			abs, err := filepath.Abs(dstDir)
+			if err != nil {
+				return &ErrExtract{Path: name, Err: err}
+			}
+			spoolDir = filepath.Dir(abs)
+		}
+		if err := os.MkdirAll(spoolDir, 0755); err != nil {
+			return &ErrExtract{Path: name, Err: err}
+		}
+		tmp, err := os.CreateTemp(spoolDir, ".gnsys-*.zip")
+		if err != nil {
+			return &ErrExtract{Path: name, Err: err}
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, r)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return &ErrExtract{Path: name, Err: err}
+		}
+		return ExtractZip(tmp.Name(), dstDir, opts...)
+
+	case TarFT, TarGzFT, TarBzFT, TarXzFt, TarZstFT, TarLz4FT, TarZFT:
+		return extractTarStream(r, ft, name, dstDir, opts)
+
+	case GzFT, Bz2FT, XzFT, ZstFT, Lz4FT, BrFT, ZFT:
+		dstPath := filepath.Join(dstDir, decompressedName(name))
+		return decompressStream(r, ft, name, dstPath, opts)
+
+	default:
+		err := fmt.Errorf("cannot extract file type '%s'", ft)
+		return &ErrExtract{Path: name, Err: err}
+	}
+}
+
+// maxLinkLen is the longest target of a symbolic link stored in a zip entry.
+const maxLinkLen = 4096
+
+// extractTarStream extracts a tar archive, possibly compressed, read from r.
+// The src is the archive path or name used in error messages.
+func extractTarStream(
+	r io.Reader,
+	ft FileType,
+	src, dstDir string,
+	opts []ExtractOption,
+) error {
+	cfg := newExtractConfig(opts)
+	prog := newProgress(&cfg, streamSize(r))
+	defer prog.finish()
+
+	compressed := &countReader{r: prog.reader(r)}
+	rc, err := newDecompressor(compressed, ft)
+	if err != nil {
+		return &ErrExtract{Path: src, Err: err}
 	}
-	defer r.Close()
+	defer rc.Close()
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dstDir, f.Name)
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return &ErrExtract{Path: fpath, Err: err}
-		}
+	e, err := newExtraction(src, dstDir, opts)
+	if err != nil {
+		return err
+	}
+	defer e.close()
+	e.limits.compressed = compressed
+	e.progress = prog
 
-		// If it's a directory, move on to the next entry.
-		if f.FileInfo().IsDir() {
-			if err := os.MkdirAll(fpath, os.ModePerm); err != nil {
-				return &ErrExtract{Path: fpath, Err: err}
-			}
-			continue
-		}
+	if err = e.untar(tar.NewReader(rc)); err != nil {
+		e.abort(err)
+		return err
+	}
+	return e.commit()
+}
 
-		// Open the file within the zip.
-		rc, err := f.Open()
+// decompressStream saves decompressed data read from r into dstPath.
+// The src is the path or name of the compressed data used in error messages.
+func decompressStream(
+	r io.Reader,
+	ft FileType,
+	src, dstPath string,
+	opts []ExtractOption,
+) error {
+	// Writing the result over its own source would destroy it while it
+	// is read.
+	if f, ok := r.(*os.File); ok {
+		srcInfo, err := f.Stat()
 		if err != nil {
-			return &ErrExtract{Path: fpath, Err: err}
+			return &ErrExtract{Path: src, Err: err}
 		}
-		defer rc.Close()
+		if fi, err := os.Stat(dstPath); err == nil && os.SameFile(srcInfo, fi) {
+			err = fmt.Errorf("result '%s' is the source file", dstPath)
+			return &ErrExtract{Path: src, Err: err}
+		}
+	}
 
-		// Create a file in the filesystem.
-		outFile, err := os.OpenFile(
-			fpath,
-			os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-			f.Mode(),
-		)
+	cfg := newExtractConfig(opts)
+	prog := newProgress(&cfg, streamSize(r))
+	defer prog.finish()
+
+	compressed := &countReader{r: prog.reader(r)}
+	rc, err := newDecompressor(compressed, ft)
+	if err != nil {
+		return &ErrExtract{Path: src, Err: err}
+	}
+	defer rc.Close()
+
+	return writeDecompressed(rc, dstPath, cfg, newLimiter(&cfg, src, compressed))
+}
+
+// extraction keeps the state of one archive extraction. All files are
+// created through os.Root, so no entry can be written outside of dstDir,
+// not even by following a symbolic link.
+type extraction struct {
+	cfg extractConfig
+
+	// src is the archive path or name used in error messages.
+	src string
+
+	// dstDir is the directory the archive is extracted into.
+	dstDir string
+
+	// root restricts file operations to dstDir, or to the staging
+	// directory of an atomic extraction.
+	root *os.Root
+
+	// target gives access to files of dstDir that might be in the way of
+	// extracted entries. It is the same as root without staging, and nil
+	// if an atomic extraction goes to a new directory.
+	target *os.Root
+
+	// dirs keep attributes of directories. They are applied after all
+	// files are extracted, because writing into a directory changes its
+	// modification time and might be forbidden by its permissions.
+	dirs []entryAttrs
+
+	// found keeps explicitly requested entries that were extracted.
+	found map[string]bool
+
+	// limits enforce limits of extraction.
+	limits *limiter
+
+	// created are files and directories made by the extraction, in order.
+	// They are removed if a limit is exceeded.
+	created []string
+
+	// own are names of files and directories made by the extraction. They
+	// are not considered existing by the overwrite policy.
+	own map[string]bool
+
+	// renamed maps names of entries to names given by the RenameNew policy.
+	renamed map[string]string
+
+	// mu guards created, own, renamed and the report during parallel
+	// extraction.
+	mu sync.Mutex
+
+	// createdDst is true if dstDir did not exist before the extraction.
+	createdDst bool
+
+	// stage is the staging directory of an atomic extraction.
+	stage string
+
+	// progress shows the progress of the extraction, if enabled.
+	progress *progress
+}
+
+// entryAttrs are permissions and times of an archive entry.
+type entryAttrs struct {
+	entry, name  string
+	mode         os.FileMode
+	mtime, atime time.Time
+}
+
+// newExtraction creates dstDir if needed and prepares its extraction.
+func newExtraction(
+	src, dstDir string,
+	opts []ExtractOption,
+) (*extraction, error) {
+	res := &extraction{
+		cfg:     newExtractConfig(opts),
+		src:     src,
+		dstDir:  dstDir,
+		found:   make(map[string]bool),
+		own:     make(map[string]bool),
+		renamed: make(map[string]string),
+	}
+	res.limits = newLimiter(&res.cfg, src, nil)
+
+	f := &res.cfg.filter
+	if err := checkPatterns(slices.Concat(f.include, f.exclude)); err != nil {
+		return nil, &ErrExtract{Path: src, Err: err}
+	}
+
+	dir := dstDir
+	if res.cfg.atomic {
+		stage, err := newStage(dstDir)
 		if err != nil {
-			return &ErrExtract{Path: fpath, Err: err}
+			return nil, &ErrExtract{Path: src, Err: err}
+		}
+		res.stage, dir = stage, stage
+	} else {
+		_, err := os.Stat(dstDir)
+		res.createdDst = os.IsNotExist(err)
+		if err = os.MkdirAll(dstDir, 0755); err != nil {
+			return nil, &ErrExtract{Path: src, Err: err}
 		}
-		defer outFile.Close()
+	}
 
-		// Copy the contents of the file from the zip to the new file.
-		_, err = io.Copy(outFile, rc)
+	var err error
+	res.root, err = os.OpenRoot(dir)
+	if err != nil {
+		res.close()
+		return nil, &ErrExtract{Path: src, Err: err}
+	}
+	res.target = res.root
+	if res.stage != "" {
+		res.target, err = os.OpenRoot(dstDir)
+		if os.IsNotExist(err) {
+			res.target, err = nil, nil
+		}
 		if err != nil {
-			return &ErrExtract{Path: fpath, Err: err}
+			res.close()
+			return nil, &ErrExtract{Path: src, Err: err}
 		}
 	}
+	return res, nil
+}
 
-	return nil
+// close releases resources of the extraction. The staging directory of
+// an atomic extraction is removed, unless it was committed.
+func (e *extraction) close() {
+	if e.target != nil && e.target != e.root {
+		e.target.Close()
+	}
+	if e.root != nil {
+		e.root.Close()
+	}
+	if e.stage != "" {
+		os.RemoveAll(e.stage)
+	}
 }
 
-// ExtractGz extracts a gz compressed file located at srcPath to the
-// destination directory dstDir.
-func ExtractGz(srcPath, dstDir string) error {
-	gzReader, cleanup, err := newGzReader(srcPath)
-	if err != nil {
+// commit moves the result of an atomic extraction from the staging
+// directory to dstDir and applies attributes of directories there. It does
+// nothing for other extractions.
+func (e *extraction) commit() error {
+	if e.stage == "" {
+		return nil
+	}
+	if err := e.cfg.check(); err != nil {
 		return err
 	}
-	defer cleanup()
+	e.root.Close()
+	e.root = nil
+	if err := mergeStage(e.stage, e.dstDir); err != nil {
+		return &ErrExtract{Path: e.src, Err: err}
+	}
+	// Directories that were merged are left in the stage.
+	os.RemoveAll(e.stage)
+	e.stage = ""
 
-	// Determine the destination file name.
-	dstFileName := filepath.Base(srcPath)
-	dstFileName = dstFileName[:len(dstFileName)-3] // Remove ".gz" extension
-	dstPath := filepath.Join(dstDir, dstFileName)
+	var err error
+	if e.root, err = os.OpenRoot(e.dstDir); err != nil {
+		return &ErrExtract{Path: e.src, Err: err}
+	}
+	return e.setDirAttrs()
+}
 
-	// Create the destination file.
-	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-	if err != nil {
-		return &ErrExtract{Path: dstPath, Err: err}
+// links gives access to links that are in place when a new symbolic link
+// is created. Files of the staging directory hide files of dstDir.
+func (e *extraction) links() linkFS {
+	if e.stage == "" {
+		return e.root
 	}
-	defer dstFile.Close()
+	return stagedFS{stage: e.root, dst: e.target}
+}
 
-	// Copy the file contents from the gzip reader to the destination file.
-	if _, err := io.Copy(dstFile, gzReader); err != nil {
-		return &ErrExtract{Path: dstPath, Err: err}
+// lstatTarget returns information about a file of dstDir that is in the way
+// of an entry.
+func (e *extraction) lstatTarget(name string) (os.FileInfo, error) {
+	if e.target == nil {
+		return nil, os.ErrNotExist
 	}
+	return e.target.Lstat(name)
+}
 
-	return nil
+// abort removes everything the extraction has written, if it was stopped
+// by a limit. Directories that existed before are kept.
+func (e *extraction) abort(err error) {
+	var errLimit *ErrLimit
+	if !errors.As(err, &errLimit) {
+		return
+	}
+	for _, v := range slices.Backward(e.created) {
+		e.root.Remove(v)
+	}
+	if e.createdDst {
+		os.Remove(e.dstDir)
+	}
 }
 
-// ExtractBz2 extracts a bz2 compressed file located at srcPath to the
-// destination directory dstDir.
-func ExtractBz2(srcPath, dstDir string) error {
-	bzReader, cleanup, err := newBz2Reader(srcPath)
-	if err != nil {
-		return err
+// addWritten remembers a file or directory written by the extraction.
+// Only new ones are removed if a limit is exceeded, files that existed
+// before the extraction are kept.
+func (e *extraction) addWritten(name string, isNew bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if isNew {
+		e.created = append(e.created, name)
 	}
-	defer cleanup()
+	e.own[name] = true
+}
 
-	// Determine the destination file name.
-	dstFileName := filepath.Base(srcPath)
-	dstFileName = dstFileName[:len(dstFileName)-4] // Remove ".bz2" extension
-	dstPath := filepath.Join(dstDir, dstFileName)
+// exists checks if there is a file, a link or a directory at name.
+func (e *extraction) exists(name string) bool {
+	_, err := e.root.Lstat(name)
+	return !os.IsNotExist(err)
+}
 
-	// Create the destination file.
-	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-	if err != nil {
-		return &ErrExtract{Path: dstPath, Err: err}
+// resolve applies the overwrite policy to an entry that is about to be
+// written. Files made by the extraction itself are replaced, as later
+// entries of an archive win. It returns the name to write the entry to,
+// or an empty string if the entry is skipped.
+func (e *extraction) resolve(attrs entryAttrs) (string, error) {
+	name := attrs.name
+	if e.cfg.overwrite == OverwriteExisting {
+		return name, nil
 	}
-	defer dstFile.Close()
 
-	// Copy the file contents from the bzip2 reader to the destination file.
-	if _, err := io.Copy(dstFile, bzReader); err != nil {
-		return &ErrExtract{Path: dstPath, Err: err}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.own[name] {
+		return name, nil
+	}
+	fi, err := e.lstatTarget(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return name, nil
+	}
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	exists := func(name string) bool {
+		_, err := e.lstatTarget(name)
+		return !errors.Is(err, os.ErrNotExist) || e.own[name]
+	}
+	res, err := resolveExisting(
+		&e.cfg, attrs.entry, name, fi, attrs.mtime, exists,
+	)
+	if res != "" && res != name {
+		// Reserve the name for this entry.
+		e.own[res] = true
+		e.renamed[name] = res
+	}
+	return res, err
 }
 
-// ExtractXz extracts an xz compressed file located at srcPath to the
-// destination directory dstDir.
-func ExtractXz(srcPath, dstDir string) error {
-	xzReader, cleanup, err := newXzReader(srcPath)
-	if err != nil {
-		return err
+// mkdirAll creates a directory with missing parents, remembering the ones
+// it has created.
+func (e *extraction) mkdirAll(name string) error {
+	if name == "." {
+		return nil
+	}
+	fi, err := e.root.Lstat(name)
+	if err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		// Let os.Root report the problem.
+		return e.root.MkdirAll(name, os.ModePerm)
 	}
-	defer cleanup()
-
-	// Determine the destination file name.
-	dstFileName := filepath.Base(srcPath)
-	dstFileName = dstFileName[:len(dstFileName)-3] // Remove ".xz" extension
-	dstPath := filepath.Join(dstDir, dstFileName)
 
-	// Create the destination file.
-	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-	if err != nil {
-		return &ErrExtract{Path: dstPath, Err: err}
+	if err = e.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
 	}
-	defer dstFile.Close()
+	err = e.root.Mkdir(name, os.ModePerm)
+	if err == nil {
+		e.addWritten(name, true)
+	}
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
 
-	// Copy the file contents from the xz reader to the destination file.
-	if _, err := io.Copy(dstFile, xzReader); err != nil {
-		return &ErrExtract{Path: dstPath, Err: err}
+// finish verifies that all explicitly requested entries were found and
+// applies attributes of directories. Atomic extraction applies them after
+// the merge, because read-only directories cannot be merged.
+func (e *extraction) finish() error {
+	var missing []string
+	for k := range e.cfg.filter.entries {
+		if !e.found[k] {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		err := fmt.Errorf("entries not found: %s", strings.Join(missing, ", "))
+		return &ErrExtract{Path: e.src, Err: err}
+	}
+	if e.stage != "" {
+		return nil
 	}
+	return e.setDirAttrs()
+}
 
+// setDirAttrs applies attributes of extracted directories, deepest first.
+func (e *extraction) setDirAttrs() error {
+	for _, v := range slices.Backward(e.dirs) {
+		if err := e.setAttrs(v); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// ExtractTar extracts a tar archive located at srcPath to the destination
-// directory dstDir.
-func ExtractTar(srcPath, dstDir string) error {
-	// Open the tar archive for reading.
-	file, err := os.Open(srcPath)
-	if err != nil {
-		return &ErrExtract{Path: srcPath, Err: err}
+// keeps checks if an entry passes the filter and applies OptStripComponents
+// and OptRename to its validated name. It returns false if the entry has to
+// be skipped. Explicitly requested entries count as found only if they are
+// kept.
+func (e *extraction) keeps(name string) (string, bool, error) {
+	key := cleanEntryName(name)
+	if !e.cfg.filter.selects(key) {
+		return "", false, nil
 	}
-	defer file.Close()
-
-	tr := tar.NewReader(file)
-	return untar(tr, srcPath, dstDir)
+	res, ok, err := e.mapName(name)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if e.cfg.filter.entries[key] {
+		e.found[key] = true
+	}
+	return res, true, nil
 }
 
-// ExtractTarGz extracts a tar.gz archive located at srcPath to the destination
-// directory dstDir.
-func ExtractTarGz(srcPath, dstDir string) error {
-	gzReader, cleanup, err := newGzReader(srcPath)
-	if err != nil {
-		return err
+// mapName applies OptStripComponents and OptRename to a validated entry
+// name. It returns false if the entry has to be skipped.
+func (e *extraction) mapName(name string) (string, bool, error) {
+	if e.cfg.strip <= 0 && e.cfg.rename == nil {
+		return name, true, nil
 	}
-	defer cleanup()
 
-	tr := tar.NewReader(gzReader)
-	return untar(tr, srcPath, dstDir)
-}
+	res := filepath.ToSlash(name)
+	if e.cfg.strip > 0 {
+		parts := strings.Split(res, "/")
+		if len(parts) <= e.cfg.strip {
+			return "", false, nil
+		}
+		res = strings.Join(parts[e.cfg.strip:], "/")
+	}
+	if e.cfg.rename != nil {
+		if res = e.cfg.rename(res); res == "" {
+			return "", false, nil
+		}
+	}
 
-// ExtractTarBz2 extracts a tar.bz2 archive located at srcPath to the destination
-// directory dstDir.
-func ExtractTarBz2(srcPath, dstDir string) error {
-	bzReader, cleanup, err := newBz2Reader(srcPath)
+	res, err := entryName(res)
 	if err != nil {
-		return err
+		return "", false, err
 	}
-	defer cleanup()
+	return res, res != ".", nil
+}
 
-	tr := tar.NewReader(bzReader)
-	return untar(tr, srcPath, dstDir)
+// done checks if all requested entries are extracted, and the rest of
+// the archive can be skipped.
+func (e *extraction) done() bool {
+	return e.cfg.filter.onlyEntries() &&
+		len(e.found) == len(e.cfg.filter.entries)
 }
 
-// ExtractTarXz extracts a tar.xz archive located at srcPath to the destination
-// directory dstDir.
-func ExtractTarXz(srcPath, dstDir string) error {
-	xzReader, cleanup, err := newXzReader(srcPath)
-	if err != nil {
-		return err
+// setAttrs restores permissions and times of an extracted entry.
+func (e *extraction) setAttrs(attrs entryAttrs) error {
+	if e.cfg.skipAttrs {
+		return nil
+	}
+	if attrs.mode != 0 {
+		err := e.root.Chmod(attrs.name, attrs.mode.Perm()&^e.cfg.umask)
+		if err != nil {
+			return e.entryErr(attrs.entry, err)
+		}
 	}
-	defer cleanup()
+	if !attrs.mtime.IsZero() {
+		atime := attrs.atime
+		if atime.IsZero() {
+			atime = attrs.mtime
+		}
+		if err := e.root.Chtimes(attrs.name, atime, attrs.mtime); err != nil {
+			return e.entryErr(attrs.entry, err)
+		}
+	}
+	return nil
+}
 
-	tr := tar.NewReader(xzReader)
-	return untar(tr, srcPath, dstDir)
+// entryErr creates an error about a particular archive entry. Errors about
+// exceeded limits are returned as is.
+func (e *extraction) entryErr(entry string, err error) error {
+	var errLimit *ErrLimit
+	if errors.As(err, &errLimit) {
+		return err
+	}
+	return &ErrExtract{Path: e.src, Entry: entry, Err: err}
 }
 
-// newBz2Reader opens a bz2 file and returns a reader for its decompressed content.
-// The caller must call the returned cleanup function to close the file.
-func newBz2Reader(srcPath string) (io.Reader, func(), error) {
-	file, err := os.Open(srcPath)
+// zipEntry extracts one file of a zip archive. The name is already
+// validated and converted to a relative OS path.
+func (e *extraction) zipEntry(f *zip.File, name string) error {
+	attrs := entryAttrs{
+		entry: f.Name,
+		name:  name,
+		mode:  f.Mode(),
+		mtime: f.Modified,
+	}
+
+	// If it's a directory, move on to the next entry.
+	if f.FileInfo().IsDir() {
+		if err := e.mkdirAll(name); err != nil {
+			return e.entryErr(f.Name, err)
+		}
+		e.dirs = append(e.dirs, attrs)
+		return nil
+	}
+
+	if err := e.mkdirAll(filepath.Dir(name)); err != nil {
+		return e.entryErr(f.Name, err)
+	}
+
+	// Open the file within the zip.
+	rc, err := f.Open()
 	if err != nil {
-		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+		return e.entryErr(f.Name, err)
 	}
-	bzReader := bzip2.NewReader(file)
-	return bzReader, func() { file.Close() }, nil
+	defer rc.Close()
+
+	// Symbolic links keep their target as the content.
+	if f.Mode()&os.ModeSymlink != 0 {
+		target, err := io.ReadAll(io.LimitReader(rc, maxLinkLen+1))
+		if err != nil {
+			return e.entryErr(f.Name, err)
+		}
+		if len(target) > maxLinkLen {
+			return e.entryErr(f.Name, errors.New("symlink target is too long"))
+		}
+		return e.symlink(attrs, string(target))
+	}
+
+	return e.writeFile(rc, attrs, int64(f.CompressedSize64))
 }
 
-// newXzReader opens an xz file and returns a reader for its decompressed content.
-// The caller must call the returned cleanup function to close the file.
-func newXzReader(srcPath string) (io.Reader, func(), error) {
-	file, err := os.Open(srcPath)
+// writeFile creates a regular file from the content of an archive entry
+// and restores its attributes. An existing symbolic link at its place is
+// replaced, not followed, and so is a read-only file. The packed size is
+// the compressed size of the entry, or zero if it is unknown.
+func (e *extraction) writeFile(
+	r io.Reader,
+	attrs entryAttrs,
+	packed int64,
+) error {
+	entry := attrs.entry
+	name, err := e.resolve(attrs)
 	if err != nil {
-		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+		return e.entryErr(entry, err)
 	}
-	xzReader, err := xz.NewReader(file)
-	if err != nil {
-		file.Close()
-		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+	if name == "" {
+		return nil
 	}
-	return xzReader, func() { file.Close() }, nil
-}
+	attrs.name = name
+	isNew := !e.exists(name)
 
-// newGzReader opens a gz file and returns a reader for its decompressed content.
-// The caller must call the returned cleanup function to close resources.
-func newGzReader(srcPath string) (io.Reader, func(), error) {
-	file, err := os.Open(srcPath)
+	if fi, err := e.root.Lstat(name); err == nil &&
+		(fi.Mode()&os.ModeSymlink != 0 || fi.Mode().Perm()&0o200 == 0) {
+		if err = e.root.Remove(name); err != nil {
+			return e.entryErr(entry, err)
+		}
+	}
+
+	// Create a file in the filesystem.
+	outFile, err := e.root.OpenFile(
+		name,
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0666,
+	)
 	if err != nil {
-		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+		return e.entryErr(entry, err)
+	}
+	e.addWritten(name, isNew)
+
+	// Copy the contents of the entry to the new file.
+	_, err = io.Copy(e.limits.writer(outFile, entry, packed), r)
+	if cerr := outFile.Close(); err == nil {
+		err = cerr
 	}
-	gzReader, err := gzip.NewReader(file)
 	if err != nil {
-		file.Close()
-		return nil, nil, &ErrExtract{Path: srcPath, Err: err}
+		return e.entryErr(entry, err)
 	}
-	return gzReader, func() { gzReader.Close(); file.Close() }, nil
+	return e.setAttrs(attrs)
 }
 
-func untar(tarReader *tar.Reader, srcPath, dstDir string) error {
-	var writer *os.File
-	for {
+// untar extracts all entries of a tar archive.
+func (e *extraction) untar(tarReader *tar.Reader) error {
+	for !e.done() {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return &ErrExtract{Path: srcPath, Err: err}
+			return &ErrExtract{Path: e.src, Err: err}
 		}
+		e.progress.entry()
 
-		// Get the individual filepath from the header.
-		filepath := filepath.Join(dstDir, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			// Handle directory.
-			err = os.MkdirAll(filepath, os.FileMode(header.Mode))
-			if err != nil {
-				return &ErrExtract{Path: srcPath, Err: err}
-			}
-		case tar.TypeReg:
-			// Handle regular file.
-			writer, err = os.Create(filepath)
-			if err != nil {
-				return &ErrExtract{Path: srcPath, Err: err}
-			}
-			if _, err := io.Copy(writer, tarReader); err != nil {
-				writer.Close()
-				return &ErrExtract{Path: srcPath, Err: err}
-			}
-			writer.Close()
-		default:
-			return &ErrExtract{Path: srcPath, Err: err}
+		if err = e.tarEntry(tarReader, header); err != nil {
+			return err
 		}
 	}
-	state := GetDirState(dstDir)
-	if state == DirEmpty {
+
+	if err := e.finish(); err != nil {
+		return err
+	}
+
+	// With a filter an empty result is legitimate. Entries skipped by the
+	// overwrite policy of an atomic extraction are in dstDir only.
+	state := GetDirState(e.root.Name())
+	if state == DirEmpty && e.target != nil && e.target != e.root {
+		state = GetDirState(e.dstDir)
+	}
+	if state == DirEmpty && !e.cfg.filter.active() {
 		return &ErrExtract{
-			Path: dstDir,
+			Path: e.dstDir,
 			Err:  errors.New("bad tar file"),
 		}
 	}
 	return nil
 }
+
+// tarEntry extracts one entry of a tar archive.
+func (e *extraction) tarEntry(tr *tar.Reader, header *tar.Header) error {
+	// PAX global headers keep metadata, not files.
+	if header.Typeflag == tar.TypeXGlobalHeader {
+		return nil
+	}
+
+	// Get the individual path from the header.
+	name, err := entryName(header.Name)
+	if err != nil {
+		return e.entryErr(header.Name, err)
+	}
+	name, ok, err := e.keeps(name)
+	if err != nil {
+		return e.entryErr(header.Name, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := e.limits.addEntry(header.Name); err != nil {
+		return err
+	}
+	if err := e.limits.checkDeclared(header.Name, header.Size, 0); err != nil {
+		return err
+	}
+
+	if header.Typeflag != tar.TypeDir {
+		err = e.mkdirAll(filepath.Dir(name))
+		if err != nil {
+			return e.entryErr(header.Name, err)
+		}
+	}
+
+	attrs := entryAttrs{
+		entry: header.Name,
+		name:  name,
+		mode:  header.FileInfo().Mode(),
+		mtime: header.ModTime,
+		atime: header.AccessTime,
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		// Handle directory.
+		err = e.mkdirAll(name)
+		if err != nil {
+			return e.entryErr(header.Name, err)
+		}
+		e.dirs = append(e.dirs, attrs)
+	case tar.TypeReg, tar.TypeGNUSparse:
+		// Handle regular file.
+		return e.writeFile(tr, attrs, 0)
+	case tar.TypeSymlink:
+		return e.symlink(attrs, header.Linkname)
+	case tar.TypeLink:
+		return e.hardlink(header, attrs)
+	default:
+		err = fmt.Errorf(
+			"unsupported entry type '%s'", tarTypeName(header.Typeflag),
+		)
+		switch e.cfg.unsupported {
+		case SkipUnsupported:
+		case WarnUnsupported:
+			slog.Warn("skipping archive entry",
+				"archive", e.src, "entry", header.Name, "reason", err)
+		default:
+			return e.entryErr(header.Name, err)
+		}
+	}
+	return nil
+}
+
+// symlink creates a symbolic link. Its target must be relative and must
+// stay inside dstDir.
+func (e *extraction) symlink(attrs entryAttrs, linkname string) error {
+	entry, name := attrs.entry, attrs.name
+	err := checkSymlink(e.links(), name, linkname, e.dstDir)
+	if err != nil {
+		return e.entryErr(entry, err)
+	}
+	target := filepath.FromSlash(linkname)
+
+	name, err = e.resolve(attrs)
+	if err != nil {
+		return e.entryErr(entry, err)
+	}
+	if name == "" {
+		return nil
+	}
+	isNew := !e.exists(name)
+	if err := e.removeExisting(name); err != nil {
+		return e.entryErr(entry, err)
+	}
+	if err := e.root.Symlink(target, name); err != nil {
+		return e.entryErr(entry, err)
+	}
+	e.addWritten(name, isNew)
+	return nil
+}
+
+// hardlink creates a hard link to a previously extracted file. Its target
+// is relative to the archive root and must stay inside dstDir.
+func (e *extraction) hardlink(header *tar.Header, attrs entryAttrs) error {
+	target, err := entryName(header.Linkname)
+	if err != nil {
+		err = fmt.Errorf("hardlink target '%s': %w", header.Linkname, err)
+		return e.entryErr(header.Name, err)
+	}
+	// The target is extracted under a new name too.
+	target, ok, err := e.mapName(target)
+	if err == nil && !ok {
+		err = errors.New("it is not extracted")
+	}
+	if err != nil {
+		err = fmt.Errorf("hardlink target '%s': %w", header.Linkname, err)
+		return e.entryErr(header.Name, err)
+	}
+	// The target might be saved under a free name.
+	if v, ok := e.renamed[target]; ok {
+		target = v
+	}
+
+	name, err := e.resolve(attrs)
+	if err != nil {
+		return e.entryErr(header.Name, err)
+	}
+	if name == "" {
+		return nil
+	}
+	isNew := !e.exists(name)
+	if err = e.removeExisting(name); err != nil {
+		return e.entryErr(header.Name, err)
+	}
+	if err = e.root.Link(target, name); err != nil {
+		return e.entryErr(header.Name, err)
+	}
+	e.addWritten(name, isNew)
+	return nil
+}
+
+// removeExisting removes a file or a link that occupies the place of
+// a new link. Directories are not removed.
+func (e *extraction) removeExisting(name string) error {
+	fi, err := e.root.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("directory '%s' is in the way", name)
+	}
+	return e.root.Remove(name)
+}
+
+// tarTypeName returns a human readable name of a tar entry type.
+func tarTypeName(flag byte) string {
+	switch flag {
+	case tar.TypeChar:
+		return "character device"
+	case tar.TypeBlock:
+		return "block device"
+	case tar.TypeFifo:
+		return "FIFO"
+	case tar.TypeCont:
+		return "contiguous file"
+	case tar.TypeXHeader:
+		return "PAX header"
+	case tar.TypeGNULongName, tar.TypeGNULongLink:
+		return "GNU long name"
+	default:
+		return fmt.Sprintf("%q", flag)
+	}
+}
+
+// entryName converts the name of an archive entry to a clean relative path.
+// It returns an error if the name is absolute or points outside of the
+// destination directory (e.g. "../../etc/x").
+func entryName(name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") ||
+		strings.HasPrefix(name, `\`) || filepath.VolumeName(name) != "" {
+		return "", errors.New("absolute entry path is not allowed")
+	}
+
+	res := filepath.Clean(filepath.FromSlash(name))
+	if escapes(res) {
+		return "", errors.New("entry path is outside of destination directory")
+	}
+	return res, nil
+}
+
+// escapes checks if a clean relative path leads outside of its base
+// directory.
+func escapes(path string) bool {
+	return path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
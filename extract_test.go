@@ -1,9 +1,21 @@
 package gnsys_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gnames/gnsys"
 	"github.com/stretchr/testify/assert"
@@ -59,3 +71,1040 @@ func TestExtractXZ(t *testing.T) {
 	err = os.RemoveAll(tempDir)
 	assert.Nil(err)
 }
+
+func TestExtractZST(t *testing.T) {
+	assert := assert.New(t)
+	zstFile := filepath.Join("testdata", "text.txt.zst")
+	tempDir, err := os.MkdirTemp("", "gnsys-test")
+	assert.Nil(err)
+
+	err = gnsys.ExtractZst(zstFile, tempDir)
+	assert.Nil(err)
+
+	exists, err := gnsys.FileExists(filepath.Join(tempDir, "text.txt"))
+	assert.Nil(err)
+	assert.True(exists)
+
+	err = os.RemoveAll(tempDir)
+	assert.Nil(err)
+}
+
+func TestExtractTarZST(t *testing.T) {
+	assert := assert.New(t)
+	tempDir := t.TempDir()
+	err := gnsys.ExtractTarZst(filepath.Join("testdata", "data.tar.zst"), tempDir)
+	assert.Nil(err)
+
+	res, err := os.ReadFile(filepath.Join(tempDir, "data", "a.txt"))
+	assert.Nil(err)
+	assert.Equal("alpha\n", string(res))
+}
+
+func TestExtractZ(t *testing.T) {
+	assert := assert.New(t)
+	tempDir := t.TempDir()
+	err := gnsys.ExtractTarZ(filepath.Join("testdata", "data.tar.Z"), tempDir)
+	assert.Nil(err)
+
+	res, err := os.ReadFile(filepath.Join(tempDir, "data", "a.txt"))
+	assert.Nil(err)
+	assert.Equal("alpha\n", string(res))
+
+	// names.txt.Z uses 10-bit codes, so the table is filled and cleared
+	// several times.
+	err = gnsys.ExtractZ(filepath.Join("testdata", "names.txt.Z"), tempDir)
+	assert.Nil(err)
+	res, err = os.ReadFile(filepath.Join(tempDir, "names.txt"))
+	assert.Nil(err)
+	assert.Equal(28500, len(res))
+	sum := sha256.Sum256(res)
+	assert.Equal(
+		"a19e2b83c7f26606174a47f81abe81a462b455eb000a9260ac276fc0d70f18c1",
+		hex.EncodeToString(sum[:]),
+	)
+
+	// truncated data is an error, not a silently short file
+	content, err := os.ReadFile(filepath.Join("testdata", "names.txt.Z"))
+	assert.Nil(err)
+	path := filepath.Join(t.TempDir(), "bad.txt.Z")
+	err = os.WriteFile(path, content[:2], 0644)
+	assert.Nil(err)
+	err = gnsys.ExtractZ(path, tempDir)
+	assert.IsType(&gnsys.ErrExtract{}, err)
+}
+
+func TestExtractTraversal(t *testing.T) {
+	assert := assert.New(t)
+	names := []string{"../evil.txt", "ok/../../evil.txt", "/tmp/evil.txt"}
+
+	for _, name := range names {
+		root := t.TempDir()
+		dstDir := filepath.Join(root, "dst")
+
+		tarPath := makeTar(t, []testEntry{
+			{name: "good.txt", body: "good"},
+			{name: name, body: "evil"},
+		})
+		err := gnsys.ExtractTar(tarPath, dstDir)
+		assert.IsType(&gnsys.ErrExtract{}, err, name)
+		assert.Equal(name, err.(*gnsys.ErrExtract).Entry, name)
+		assert.False(gnsys.IsFile(filepath.Join(root, "evil.txt")), name)
+
+		zipPath := makeZip(t, []testEntry{
+			{name: "good.txt", body: "good"},
+			{name: name, body: "evil"},
+		})
+		err = gnsys.ExtractZip(zipPath, dstDir)
+		assert.IsType(&gnsys.ErrExtract{}, err, name)
+		assert.Equal(name, err.(*gnsys.ErrExtract).Entry, name)
+		assert.False(gnsys.IsFile(filepath.Join(root, "evil.txt")), name)
+	}
+}
+
+// testEntry describes an archive entry created by makeTar and makeZip.
+type testEntry struct {
+	name, body, link string
+	typ              byte
+	mode             int64
+	modTime          time.Time
+}
+
+// makeTar creates a tar archive with the given entries in a temporary
+// directory and returns its path.
+func makeTar(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, v := range entries {
+		hdr := &tar.Header{
+			Name:     v.name,
+			Typeflag: v.typ,
+			Mode:     v.mode,
+			Linkname: v.link,
+			ModTime:  v.modTime,
+		}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(v.body))
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err = tw.Write([]byte(v.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// makeZip creates a zip archive with the given entries in a temporary
+// directory and returns its path.
+func makeZip(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, v := range entries {
+		hdr := &zip.FileHeader{
+			Name:     v.name,
+			Method:   zip.Deflate,
+			Modified: v.modTime,
+		}
+		mode := os.FileMode(v.mode)
+		if mode == 0 {
+			mode = 0644
+		}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(v.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractTarLinks(t *testing.T) {
+	assert := assert.New(t)
+	entries := []testEntry{
+		{name: "data/", typ: tar.TypeDir, mode: 0755},
+		{name: "data/a.txt", body: "alpha"},
+		{name: "data/link.txt", typ: tar.TypeSymlink, link: "a.txt"},
+		{name: "top.txt", typ: tar.TypeSymlink, link: "data/a.txt"},
+		{name: "hard.txt", typ: tar.TypeLink, link: "data/a.txt"},
+	}
+	dstDir := t.TempDir()
+	err := gnsys.ExtractTar(makeTar(t, entries), dstDir)
+	assert.Nil(err)
+
+	for _, v := range []string{"data/link.txt", "top.txt", "hard.txt"} {
+		res, err := os.ReadFile(filepath.Join(dstDir, v))
+		assert.Nil(err, v)
+		assert.Equal("alpha", string(res), v)
+	}
+	fi, err := os.Lstat(filepath.Join(dstDir, "top.txt"))
+	assert.Nil(err)
+	assert.True(fi.Mode()&os.ModeSymlink != 0)
+
+	bad := []testEntry{
+		{name: "up.txt", typ: tar.TypeSymlink, link: "../outside.txt"},
+		{name: "data/up.txt", typ: tar.TypeSymlink, link: "../../outside.txt"},
+		{name: "abs.txt", typ: tar.TypeSymlink, link: "/etc/passwd"},
+		{name: "hard.txt", typ: tar.TypeLink, link: "../outside.txt"},
+	}
+	for _, v := range bad {
+		err = gnsys.ExtractTar(makeTar(t, []testEntry{v}), t.TempDir())
+		assert.IsType(&gnsys.ErrExtract{}, err, v.name)
+		assert.Equal(v.name, err.(*gnsys.ErrExtract).Entry)
+	}
+
+	// links are resolved through links extracted before them
+	chained := [][]testEntry{
+		{
+			{name: "d/", typ: tar.TypeDir, mode: 0755},
+			{name: "d/s", typ: tar.TypeSymlink, link: ".."},
+			{name: "t", typ: tar.TypeSymlink, link: "d/s/.."},
+		},
+		// t is safe while d/s points to x/y, but d/s could be replaced
+		{
+			{name: "x/y/", typ: tar.TypeDir, mode: 0755},
+			{name: "d/", typ: tar.TypeDir, mode: 0755},
+			{name: "d/s", typ: tar.TypeSymlink, link: "../x/y"},
+			{name: "t", typ: tar.TypeSymlink, link: "d/s/.."},
+			{name: "d/s", typ: tar.TypeSymlink, link: ".."},
+		},
+		// d/f is a file, that could be replaced by a link
+		{
+			{name: "d/f", body: "file"},
+			{name: "t", typ: tar.TypeSymlink, link: "d/f/../.."},
+		},
+	}
+	for _, v := range chained {
+		dstDir := filepath.Join(t.TempDir(), "dst")
+		err = gnsys.ExtractTar(makeTar(t, v), dstDir)
+		assert.IsType(&gnsys.ErrExtract{}, err)
+		assert.Equal("t", err.(*gnsys.ErrExtract).Entry)
+		_, err = os.Lstat(filepath.Join(dstDir, "t"))
+		assert.True(os.IsNotExist(err))
+	}
+
+	zipPath := makeZip(t, []testEntry{
+		{name: "d/", mode: int64(os.ModeDir | 0755)},
+		{name: "d/s", mode: int64(os.ModeSymlink | 0777), body: ".."},
+		{name: "t", mode: int64(os.ModeSymlink | 0777), body: "d/s/.."},
+	})
+	err = gnsys.ExtractZip(zipPath, t.TempDir())
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Equal("t", err.(*gnsys.ErrExtract).Entry)
+
+	// links to links are fine, if they do not go up after them
+	entries = []testEntry{
+		{name: "usr/lib/", typ: tar.TypeDir, mode: 0755},
+		{name: "usr/lib/a.so", body: "alpha"},
+		{name: "d/", typ: tar.TypeDir, mode: 0755},
+		{name: "d/lib", typ: tar.TypeSymlink, link: "../usr/lib"},
+		{name: "a.so", typ: tar.TypeSymlink, link: "d/lib/a.so"},
+	}
+	dstDir = t.TempDir()
+	err = gnsys.ExtractTar(makeTar(t, entries), dstDir)
+	assert.Nil(err)
+	res, err := os.ReadFile(filepath.Join(dstDir, "a.so"))
+	assert.Nil(err)
+	assert.Equal("alpha", string(res))
+}
+
+func TestExtractTarUnsupported(t *testing.T) {
+	assert := assert.New(t)
+	entries := []testEntry{
+		{name: "a.txt", body: "alpha"},
+		{name: "pipe", typ: tar.TypeFifo},
+	}
+	tarPath := makeTar(t, entries)
+
+	err := gnsys.ExtractTar(tarPath, t.TempDir())
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Contains(err.Error(), "unsupported entry type 'FIFO'")
+
+	dstDir := t.TempDir()
+	err = gnsys.ExtractTar(tarPath, dstDir,
+		gnsys.OptUnsupported(gnsys.SkipUnsupported))
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(filepath.Join(dstDir, "a.txt")))
+	assert.False(gnsys.IsFile(filepath.Join(dstDir, "pipe")))
+}
+
+func TestExtractAttrs(t *testing.T) {
+	assert := assert.New(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []testEntry{
+		{name: "bin/", typ: tar.TypeDir, mode: 0750, modTime: mtime},
+		{name: "bin/run.sh", body: "#!/bin/sh\n", mode: 0777, modTime: mtime},
+		{name: "bin/ro.txt", body: "read only", mode: 0444, modTime: mtime},
+	}
+
+	archives := map[string]func(string, string, ...gnsys.ExtractOption) error{
+		makeTar(t, entries): gnsys.ExtractTar,
+		makeZip(t, entries): gnsys.ExtractZip,
+	}
+	for path, extract := range archives {
+		dstDir := t.TempDir()
+		err := extract(path, dstDir)
+		assert.Nil(err)
+		// second extraction replaces read-only files
+		err = extract(path, dstDir)
+		assert.Nil(err)
+
+		fi, err := os.Stat(filepath.Join(dstDir, "bin", "run.sh"))
+		assert.Nil(err)
+		assert.Equal(os.FileMode(0755), fi.Mode().Perm(), path)
+		assert.True(mtime.Equal(fi.ModTime()), path)
+
+		fi, err = os.Stat(filepath.Join(dstDir, "bin", "ro.txt"))
+		assert.Nil(err)
+		assert.Equal(os.FileMode(0444), fi.Mode().Perm(), path)
+
+		fi, err = os.Stat(filepath.Join(dstDir, "bin"))
+		assert.Nil(err)
+		assert.Equal(os.FileMode(0750), fi.Mode().Perm(), path)
+		assert.True(mtime.Equal(fi.ModTime()), path)
+
+		dstDir = t.TempDir()
+		err = extract(path, dstDir, gnsys.OptUmask(0077))
+		assert.Nil(err)
+		fi, err = os.Stat(filepath.Join(dstDir, "bin", "run.sh"))
+		assert.Nil(err)
+		assert.Equal(os.FileMode(0700), fi.Mode().Perm(), path)
+
+		dstDir = t.TempDir()
+		err = extract(path, dstDir, gnsys.OptPreserveAttrs(false))
+		assert.Nil(err)
+		fi, err = os.Stat(filepath.Join(dstDir, "bin", "run.sh"))
+		assert.Nil(err)
+		assert.False(mtime.Equal(fi.ModTime()), path)
+	}
+}
+
+func TestExtractorFor(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		ft    gnsys.FileType
+		isErr bool
+	}{
+		{gnsys.ZipFT, false},
+		{gnsys.GzFT, false},
+		{gnsys.TarFT, false},
+		{gnsys.TarGzFT, false},
+		{gnsys.TarXzFt, false},
+		{gnsys.TarBzFT, false},
+		{gnsys.Bz2FT, false},
+		{gnsys.XzFT, false},
+		{gnsys.ZstFT, false},
+		{gnsys.TarZstFT, false},
+		{gnsys.Lz4FT, false},
+		{gnsys.TarLz4FT, false},
+		{gnsys.BrFT, false},
+		{gnsys.ZFT, false},
+		{gnsys.TarZFT, false},
+		{gnsys.SqlFT, true},
+		{gnsys.SqliteFT, true},
+		{gnsys.UnknownFT, true},
+	}
+
+	for _, v := range tests {
+		ext, err := gnsys.ExtractorFor(v.ft)
+		assert.Equal(v.isErr, err != nil, v.ft.String())
+		assert.Equal(v.isErr, ext == nil, v.ft.String())
+		if v.isErr {
+			assert.IsType(&gnsys.ErrNoExtractor{}, err)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		msg, file, result string
+		isErr             bool
+	}{
+		{"zip", "data.zip", "data/sub/c.txt", false},
+		{"tar.gz", "data.tar.gz", "data/sub/c.txt", false},
+		{"gz", "text.txt.gz", "text.txt", false},
+		{"xz", "text.txt.xz", "text.txt", false},
+		{"zst", "text.txt.zst", "text.txt", false},
+		{"tar.zst", "data.tar.zst", "data/sub/c.txt", false},
+		{"lz4", "text.txt.lz4", "text.txt", false},
+		{"tar.lz4", "data.tar.lz4", "data/sub/c.txt", false},
+		{"br", "text.txt.br", "text.txt", false},
+		{"Z", "text.txt.Z", "text.txt", false},
+		{"tar.Z", "data.tar.Z", "data/sub/c.txt", false},
+		{"txt", "text.txt", "", true},
+	}
+
+	for _, v := range tests {
+		dstDir := t.TempDir()
+		err := gnsys.Extract(filepath.Join("testdata", v.file), dstDir)
+		assert.Equal(v.isErr, err != nil, v.msg)
+		if v.isErr {
+			continue
+		}
+		assert.True(gnsys.IsFile(filepath.Join(dstDir, v.result)), v.msg)
+	}
+}
+
+func TestExtractMisnamed(t *testing.T) {
+	assert := assert.New(t)
+	content, err := os.ReadFile(filepath.Join("testdata", "data.tar.gz"))
+	assert.Nil(err)
+	path := filepath.Join(t.TempDir(), "data.zip")
+	err = os.WriteFile(path, content, 0644)
+	assert.Nil(err)
+
+	dstDir := t.TempDir()
+	err = gnsys.Extract(path, dstDir)
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(filepath.Join(dstDir, "data", "sub", "c.txt")))
+}
+
+func TestExtractNoExtension(t *testing.T) {
+	assert := assert.New(t)
+	payload := strings.Repeat("precious data\n", 1<<16)
+	dir := t.TempDir()
+	txtPath := filepath.Join(dir, "dump.txt")
+	err := os.WriteFile(txtPath, []byte(payload), 0644)
+	assert.Nil(err)
+	err = gnsys.CompressGz(txtPath, dir)
+	assert.Nil(err)
+	srcPath := filepath.Join(dir, "dump")
+	err = os.Rename(txtPath+".gz", srcPath)
+	assert.Nil(err)
+	src, err := os.ReadFile(srcPath)
+	assert.Nil(err)
+
+	// the result gets its own name in the directory of the source
+	err = gnsys.Extract(srcPath, dir)
+	assert.Nil(err)
+	res, err := os.ReadFile(filepath.Join(dir, "dump.out"))
+	assert.Nil(err)
+	assert.Equal(payload, string(res))
+	res, err = os.ReadFile(srcPath)
+	assert.Nil(err)
+	assert.Equal(src, res)
+
+	err = gnsys.ExtractReader(bytes.NewReader(src), "dump", dir)
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(filepath.Join(dir, "dump.out")))
+
+	// the source is never overwritten by its result
+	gzPath := filepath.Join(dir, "data.gz")
+	err = os.Rename(srcPath, gzPath)
+	assert.Nil(err)
+	err = os.Link(gzPath, filepath.Join(dir, "data"))
+	assert.Nil(err)
+	err = gnsys.ExtractGz(gzPath, dir)
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	res, err = os.ReadFile(gzPath)
+	assert.Nil(err)
+	assert.Equal(src, res)
+}
+
+func TestExtractReader(t *testing.T) {
+	assert := assert.New(t)
+	open := func(name string) *os.File {
+		f, err := os.Open(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	dstDir := t.TempDir()
+	err := gnsys.ExtractTarGzReader(open("data.tar.gz"), dstDir)
+	assert.Nil(err)
+	res, err := os.ReadFile(filepath.Join(dstDir, "data", "a.txt"))
+	assert.Nil(err)
+	assert.Equal("alpha\n", string(res))
+
+	dstPath := filepath.Join(t.TempDir(), "out", "result.txt")
+	err = gnsys.ExtractZReader(open("names.txt.Z"), dstPath)
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(dstPath))
+
+	err = gnsys.ExtractXzReader(open("text.txt.gz"), dstPath)
+	assert.IsType(&gnsys.ErrExtract{}, err)
+
+	content, err := os.ReadFile(filepath.Join("testdata", "data.zip"))
+	assert.Nil(err)
+	dstDir = t.TempDir()
+	err = gnsys.ExtractZipReader(
+		bytes.NewReader(content), int64(len(content)), dstDir,
+	)
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(filepath.Join(dstDir, "data", "sub", "c.txt")))
+
+	tests := []struct {
+		msg, name, result string
+		r                 io.Reader
+	}{
+		{"zip file", "data.zip", "data/sub/c.txt", open("data.zip")},
+		// a plain reader is spooled into a temporary file
+		{"zip stream", "data.zip", "data/sub/c.txt",
+			io.MultiReader(bytes.NewReader(content))},
+		{"tar.Z", "stream", "data/sub/c.txt", open("data.tar.Z")},
+		{"xz", "text.txt.xz", "text.txt", open("text.txt.xz")},
+		// brotli is recognized by name only
+		{"br", "dir/text.txt.br", "text.txt", open("text.txt.br")},
+	}
+	for _, v := range tests {
+		dstDir := t.TempDir()
+		err := gnsys.ExtractReader(v.r, v.name, dstDir)
+		assert.Nil(err, v.msg)
+		assert.True(gnsys.IsFile(filepath.Join(dstDir, v.result)), v.msg)
+		entries, err := os.ReadDir(dstDir)
+		assert.Nil(err)
+		assert.Len(entries, 1, v.msg)
+	}
+
+	err = gnsys.ExtractReader(open("text.txt"), "text.txt", t.TempDir())
+	assert.IsType(&gnsys.ErrNoExtractor{}, err)
+}
+
+func TestExtractFilter(t *testing.T) {
+	assert := assert.New(t)
+	entries := []testEntry{
+		{name: "data/", typ: tar.TypeDir, mode: 0755},
+		{name: "data/a.txt", body: "alpha"},
+		{name: "data/sub/c.txt", body: "gamma"},
+		{name: "data/sub/d.log", body: "delta"},
+		{name: "data/skip/e.txt", body: "epsilon"},
+	}
+	archives := map[string]func(string, string, ...gnsys.ExtractOption) error{
+		makeTar(t, entries): gnsys.ExtractTar,
+		makeZip(t, entries): gnsys.ExtractZip,
+	}
+
+	tests := []struct {
+		msg    string
+		opts   []gnsys.ExtractOption
+		result []string
+	}{
+		{"glob",
+			[]gnsys.ExtractOption{
+				gnsys.OptInclude("*.txt"), gnsys.OptExclude("skip"),
+			},
+			[]string{"data/a.txt", "data/sub/c.txt"}},
+		{"regexp",
+			[]gnsys.ExtractOption{
+				gnsys.OptIncludeRegexp(regexp.MustCompile(`^data/sub/`)),
+				gnsys.OptExcludeRegexp(regexp.MustCompile(`\.txt$`)),
+			},
+			[]string{"data/sub/d.log"}},
+		{"entries",
+			[]gnsys.ExtractOption{gnsys.OptEntries("data/a.txt", "./data/sub/d.log")},
+			[]string{"data/a.txt", "data/sub/d.log"}},
+	}
+
+	all := []string{"data/a.txt", "data/sub/c.txt", "data/sub/d.log", "data/skip/e.txt"}
+	for path, extract := range archives {
+		for _, v := range tests {
+			dstDir := t.TempDir()
+			err := extract(path, dstDir, v.opts...)
+			assert.Nil(err, v.msg)
+			for _, name := range all {
+				exists := gnsys.IsFile(filepath.Join(dstDir, filepath.FromSlash(name)))
+				assert.Equal(slices.Contains(v.result, name), exists, v.msg+": "+name)
+			}
+		}
+
+		err := extract(path, t.TempDir(), gnsys.OptEntries("data/a.txt", "data/x.txt"))
+		assert.IsType(&gnsys.ErrExtract{}, err)
+		assert.Contains(err.Error(), "entries not found: data/x.txt")
+
+		err = extract(path, t.TempDir(), gnsys.OptInclude("["))
+		assert.IsType(&gnsys.ErrExtract{}, err)
+	}
+
+	// reading stops when all requested entries are found, so the invalid
+	// entry at the end is never reached
+	path := makeTar(t, append(entries, testEntry{name: "../evil.txt"}))
+	err := gnsys.ExtractTar(path, t.TempDir())
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	err = gnsys.ExtractTar(path, t.TempDir(), gnsys.OptEntries("data/a.txt"))
+	assert.Nil(err)
+}
+
+func TestExtractStripRename(t *testing.T) {
+	assert := assert.New(t)
+	entries := []testEntry{
+		{name: "top.txt", body: "top"},
+		{name: "dataset-2026-10/names.csv", body: "names"},
+		{name: "dataset-2026-10/sub/c.txt", body: "gamma"},
+	}
+	tarPath := makeTar(t, append(entries,
+		testEntry{name: "dataset-2026-10/hard.csv", typ: tar.TypeLink,
+			link: "dataset-2026-10/names.csv"},
+	))
+	archives := map[string]func(string, string, ...gnsys.ExtractOption) error{
+		tarPath:             gnsys.ExtractTar,
+		makeZip(t, entries): gnsys.ExtractZip,
+	}
+
+	flatten := func(name string) string {
+		if path.Ext(name) == ".csv" {
+			return ""
+		}
+		return path.Base(name)
+	}
+	tests := []struct {
+		msg    string
+		opts   []gnsys.ExtractOption
+		result []string
+	}{
+		{"strip",
+			[]gnsys.ExtractOption{gnsys.OptStripComponents(1)},
+			[]string{"names.csv", "sub/c.txt"}},
+		{"rename",
+			[]gnsys.ExtractOption{gnsys.OptRename(flatten)},
+			[]string{"top.txt", "c.txt"}},
+		{"strip and rename",
+			[]gnsys.ExtractOption{
+				gnsys.OptStripComponents(1),
+				gnsys.OptRename(func(name string) string {
+					return "new/" + name
+				}),
+			},
+			[]string{"new/names.csv", "new/sub/c.txt"}},
+	}
+
+	all := []string{
+		"top.txt", "names.csv", "sub/c.txt", "c.txt",
+		"new/names.csv", "new/sub/c.txt",
+		"dataset-2026-10/names.csv", "dataset-2026-10/sub/c.txt",
+	}
+	for path, extract := range archives {
+		for _, v := range tests {
+			dstDir := t.TempDir()
+			err := extract(path, dstDir, v.opts...)
+			assert.Nil(err, v.msg)
+			for _, name := range all {
+				exists := gnsys.IsFile(filepath.Join(dstDir, filepath.FromSlash(name)))
+				assert.Equal(slices.Contains(v.result, name), exists, v.msg+": "+name)
+			}
+		}
+
+		err := extract(path, t.TempDir(), gnsys.OptRename(func(name string) string {
+			return "../" + name
+		}))
+		assert.IsType(&gnsys.ErrExtract{}, err)
+	}
+
+	// hard links follow their renamed targets
+	dstDir := t.TempDir()
+	err := gnsys.ExtractTar(tarPath, dstDir, gnsys.OptStripComponents(1))
+	assert.Nil(err)
+	res, err := os.ReadFile(filepath.Join(dstDir, "hard.csv"))
+	assert.Nil(err)
+	assert.Equal("names", string(res))
+
+	// the target of a hard link is skipped
+	err = gnsys.ExtractTar(tarPath, t.TempDir(), gnsys.OptRename(
+		func(name string) string {
+			if path.Base(name) == "names.csv" {
+				return ""
+			}
+			return name
+		}))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Contains(err.Error(), "is not extracted")
+
+	// requested entries that are dropped by mapping are not found
+	for _, v := range []string{"tar", "zip"} {
+		path := tarPath
+		if v == "zip" {
+			path = makeZip(t, entries)
+		}
+		err = gnsys.Extract(path, t.TempDir(),
+			gnsys.OptEntries("top.txt", "dataset-2026-10/names.csv"),
+			gnsys.OptStripComponents(1))
+		assert.IsType(&gnsys.ErrExtract{}, err, v)
+		assert.Contains(err.Error(), "entries not found: top.txt", v)
+		assert.NotContains(err.Error(), "names.csv", v)
+	}
+}
+
+func TestExtractProgress(t *testing.T) {
+	assert := assert.New(t)
+	// keep the progress bar out of the test output
+	stderr := os.Stderr
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	assert.Nil(err)
+	os.Stderr = devNull
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		devNull.Close()
+	})
+
+	for _, v := range []struct{ name, result string }{
+		{"data.zip", "data/sub/c.txt"},
+		{"data.tar.xz", "data/sub/c.txt"},
+		{"text.txt.gz", "text.txt"},
+	} {
+		dstDir := t.TempDir()
+		err = gnsys.Extract(filepath.Join("testdata", v.name), dstDir,
+			gnsys.OptProgress(true))
+		assert.Nil(err, v.name)
+		assert.True(gnsys.IsFile(filepath.Join(dstDir, v.result)), v.name)
+
+		f, err := os.Open(filepath.Join("testdata", v.name))
+		assert.Nil(err)
+		dstDir = t.TempDir()
+		err = gnsys.ExtractReader(io.MultiReader(f), v.name, dstDir,
+			gnsys.OptProgress(true))
+		f.Close()
+		assert.Nil(err, v.name)
+		assert.True(gnsys.IsFile(filepath.Join(dstDir, v.result)), v.name)
+	}
+}
+
+func TestExtractZipWorkers(t *testing.T) {
+	assert := assert.New(t)
+	entries := []testEntry{
+		{name: "data/", mode: int64(os.ModeDir | 0755)},
+		{name: "data/dup.txt", body: "first"},
+	}
+	for i := range 300 {
+		entries = append(entries, testEntry{
+			name: fmt.Sprintf("data/d%d/f%d.txt", i%7, i),
+			body: strings.Repeat("x", i),
+		})
+	}
+	entries = append(entries,
+		testEntry{name: "data/dup.txt", body: "second"},
+		testEntry{name: "data/link.txt", mode: int64(os.ModeSymlink | 0777),
+			body: "d1/f1.txt"},
+	)
+	path := makeZip(t, entries)
+
+	serial := t.TempDir()
+	err := gnsys.ExtractZip(path, serial)
+	assert.Nil(err)
+	parallel := t.TempDir()
+	err = gnsys.ExtractZip(path, parallel, gnsys.OptWorkers(8))
+	assert.Nil(err)
+
+	for _, v := range entries[1:] {
+		name := filepath.FromSlash(v.name)
+		exp, err := os.ReadFile(filepath.Join(serial, name))
+		assert.Nil(err, v.name)
+		res, err := os.ReadFile(filepath.Join(parallel, name))
+		assert.Nil(err, v.name)
+		assert.Equal(exp, res, v.name)
+	}
+	res, err := os.ReadFile(filepath.Join(parallel, "data", "dup.txt"))
+	assert.Nil(err)
+	assert.Equal("second", string(res))
+	link, err := os.Readlink(filepath.Join(parallel, "data", "link.txt"))
+	assert.Nil(err)
+	assert.Equal("d1/f1.txt", link)
+
+	// a file cannot replace a directory
+	path = makeZip(t, append(entries[:100:100], testEntry{name: "data/d0"}))
+	err = gnsys.ExtractZip(path, t.TempDir(), gnsys.OptWorkers(4))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Contains(err.Error(), "data/d0")
+}
+
+func TestExtractAtomic(t *testing.T) {
+	assert := assert.New(t)
+	// names lists the content of a directory.
+	names := func(dir string) []string {
+		entries, err := os.ReadDir(dir)
+		assert.Nil(err)
+		var res []string
+		for _, v := range entries {
+			res = append(res, v.Name())
+		}
+		return res
+	}
+
+	parent := t.TempDir()
+	dstDir := filepath.Join(parent, "out")
+	err := os.MkdirAll(dstDir, 0755)
+	assert.Nil(err)
+	err = os.WriteFile(filepath.Join(dstDir, "old.txt"), []byte("old"), 0644)
+	assert.Nil(err)
+
+	bad := makeTar(t, []testEntry{
+		{name: "data/a.txt", body: "alpha"},
+		{name: "../evil.txt", body: "evil"},
+	})
+	err = gnsys.ExtractTar(bad, dstDir, gnsys.OptAtomic(true))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Equal([]string{"old.txt"}, names(dstDir))
+	assert.Equal([]string{"out"}, names(parent))
+
+	err = gnsys.ExtractTar(bad, filepath.Join(parent, "new"),
+		gnsys.OptAtomic(true))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Equal([]string{"out"}, names(parent))
+
+	// extracted files are merged with the previous content
+	dataDir := filepath.Join(dstDir, "data")
+	err = os.MkdirAll(dataDir, 0755)
+	assert.Nil(err)
+	for _, v := range []string{"a.txt", "mine.txt"} {
+		err = os.WriteFile(filepath.Join(dataDir, v), []byte("old"), 0644)
+		assert.Nil(err)
+	}
+	tarGz := filepath.Join("testdata", "data.tar.gz")
+	err = gnsys.ExtractTarGz(tarGz, dstDir, gnsys.OptAtomic(true))
+	assert.Nil(err)
+	assert.Equal([]string{"data", "old.txt"}, names(dstDir))
+	assert.Equal([]string{"a.txt", "mine.txt", "sub", "text.txt"}, names(dataDir))
+	content, err := os.ReadFile(filepath.Join(dataDir, "a.txt"))
+	assert.Nil(err)
+	assert.NotEqual("old", string(content))
+	assert.Equal([]string{"out"}, names(parent))
+
+	// the overwrite policy applies to the files of dstDir
+	err = os.WriteFile(filepath.Join(dataDir, "a.txt"), []byte("old"), 0644)
+	assert.Nil(err)
+	var report gnsys.ExtractReport
+	err = gnsys.ExtractTarGz(tarGz, dstDir, gnsys.OptAtomic(true),
+		gnsys.OptOverwrite(gnsys.SkipExisting), gnsys.OptReport(&report))
+	assert.Nil(err)
+	assert.Equal([]string{"data/text.txt", "data/sub/c.txt", "data/a.txt"},
+		report.Skipped)
+	content, err = os.ReadFile(filepath.Join(dataDir, "a.txt"))
+	assert.Nil(err)
+	assert.Equal("old", string(content))
+
+	err = gnsys.ExtractTarGz(tarGz, dstDir, gnsys.OptAtomic(true),
+		gnsys.OptOverwrite(gnsys.FailExisting))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Equal([]string{"out"}, names(parent))
+
+	err = gnsys.ExtractTarGz(tarGz, dstDir, gnsys.OptAtomic(true),
+		gnsys.OptOverwrite(gnsys.RenameNew))
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(filepath.Join(dataDir, "a.1.txt")))
+	content, err = os.ReadFile(filepath.Join(dataDir, "a.txt"))
+	assert.Nil(err)
+	assert.Equal("old", string(content))
+	assert.Equal([]string{"out"}, names(parent))
+
+	content, err = os.ReadFile(filepath.Join("testdata", "data.zip"))
+	assert.Nil(err)
+	zipDir := filepath.Join(parent, "zip")
+	err = gnsys.ExtractReader(io.MultiReader(bytes.NewReader(content)),
+		"data.zip", zipDir, gnsys.OptAtomic(true))
+	assert.Nil(err)
+	assert.Equal([]string{"data"}, names(zipDir))
+	assert.Equal([]string{"out", "zip"}, names(parent))
+
+	// single compressed files are renamed into place
+	gz, err := os.ReadFile(filepath.Join("testdata", "text.txt.gz"))
+	assert.Nil(err)
+	gzDir := t.TempDir()
+	dstPath := filepath.Join(gzDir, "text.txt")
+	err = gnsys.ExtractGzReader(bytes.NewReader(gz[:len(gz)/2]), dstPath,
+		gnsys.OptAtomic(true))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Empty(names(gzDir))
+
+	err = gnsys.ExtractGzReader(bytes.NewReader(gz), dstPath,
+		gnsys.OptAtomic(true))
+	assert.Nil(err)
+	assert.Equal([]string{"text.txt"}, names(gzDir))
+	fi, err := os.Stat(dstPath)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0644), fi.Mode().Perm())
+
+	// conflicts are found before anything is moved
+	conflict := makeTar(t, []testEntry{
+		{name: "a.txt", body: "alpha"},
+		{name: "b", body: "beta"},
+		{name: "z.txt", body: "zeta"},
+	})
+	linked := makeTar(t, []testEntry{
+		{name: "a.txt", body: "alpha"},
+		{name: "d/x.txt", body: "ex"},
+	})
+	for _, v := range []struct {
+		msg, path, existing string
+		link                bool
+	}{
+		{"directory", conflict, "b", false},
+		{"symlinked directory", linked, "d", true},
+	} {
+		parent = t.TempDir()
+		dstDir = filepath.Join(parent, "out")
+		err = os.MkdirAll(dstDir, 0755)
+		assert.Nil(err, v.msg)
+		if v.link {
+			err = os.Symlink(t.TempDir(), filepath.Join(dstDir, v.existing))
+		} else {
+			err = os.Mkdir(filepath.Join(dstDir, v.existing), 0755)
+		}
+		assert.Nil(err, v.msg)
+		err = gnsys.ExtractTar(v.path, dstDir, gnsys.OptAtomic(true))
+		assert.IsType(&gnsys.ErrExtract{}, err, v.msg)
+		assert.Equal([]string{v.existing}, names(dstDir), v.msg)
+		assert.Equal([]string{"out"}, names(parent), v.msg)
+	}
+
+	// a trailing slash and the current directory
+	tarGz, err = filepath.Abs(tarGz)
+	assert.Nil(err)
+	parent = t.TempDir()
+	for _, v := range []string{"new/", "new/", "new/."} {
+		err = gnsys.ExtractTarGz(tarGz, filepath.Join(parent, v)+"/",
+			gnsys.OptAtomic(true))
+		assert.Nil(err, v)
+		assert.Equal([]string{"data"}, names(filepath.Join(parent, "new")), v)
+		assert.Equal([]string{"new"}, names(parent), v)
+	}
+
+	dstDir = filepath.Join(parent, "cwd")
+	err = os.MkdirAll(dstDir, 0755)
+	assert.Nil(err)
+	t.Chdir(dstDir)
+	err = gnsys.ExtractTarGz(tarGz, ".", gnsys.OptAtomic(true))
+	assert.Nil(err)
+	err = gnsys.ExtractReader(bytes.NewReader(content), "data.zip", ".",
+		gnsys.OptAtomic(true))
+	assert.Nil(err)
+	assert.Equal([]string{"data"}, names(dstDir))
+	assert.Equal([]string{"cwd", "new"}, names(parent))
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	assert := assert.New(t)
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	future := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// existing creates files that are in the way of extraction.
+	existing := func() string {
+		dstDir := t.TempDir()
+		for k, v := range map[string]time.Time{"a.txt": future, "b.txt": past} {
+			path := filepath.Join(dstDir, "data", k)
+			assert.Nil(os.MkdirAll(filepath.Dir(path), 0755))
+			assert.Nil(os.WriteFile(path, []byte("old"), 0644))
+			assert.Nil(os.Chtimes(path, v, v))
+		}
+		return dstDir
+	}
+	path := makeTar(t, []testEntry{
+		{name: "data/a.txt", body: "new", modTime: mtime},
+		{name: "data/b.txt", body: "new", modTime: mtime},
+		{name: "data/c.txt", body: "new", modTime: mtime},
+		{name: "data/hard.txt", typ: tar.TypeLink, link: "data/a.txt"},
+	})
+
+	tests := []struct {
+		msg      string
+		policy   gnsys.OverwritePolicy
+		contents map[string]string
+		skipped  []string
+		renamed  map[string]string
+	}{
+		{"overwrite", gnsys.OverwriteExisting,
+			map[string]string{"a.txt": "new", "b.txt": "new", "hard.txt": "new"},
+			nil, nil},
+		{"skip", gnsys.SkipExisting,
+			map[string]string{"a.txt": "old", "b.txt": "old", "hard.txt": "old"},
+			[]string{"data/a.txt", "data/b.txt"}, nil},
+		{"older", gnsys.OverwriteOlder,
+			map[string]string{"a.txt": "old", "b.txt": "new", "hard.txt": "old"},
+			[]string{"data/a.txt"}, nil},
+		{"rename", gnsys.RenameNew,
+			map[string]string{
+				"a.txt": "old", "a.1.txt": "new", "b.txt": "old", "b.1.txt": "new",
+				"hard.txt": "new",
+			},
+			nil, map[string]string{
+				"data/a.txt": "data/a.1.txt", "data/b.txt": "data/b.1.txt",
+			}},
+	}
+	for _, v := range tests {
+		dstDir := existing()
+		var report gnsys.ExtractReport
+		err := gnsys.ExtractTar(path, dstDir,
+			gnsys.OptOverwrite(v.policy), gnsys.OptReport(&report))
+		assert.Nil(err, v.msg)
+		v.contents["c.txt"] = "new"
+		for name, content := range v.contents {
+			res, err := os.ReadFile(filepath.Join(dstDir, "data", name))
+			assert.Nil(err, v.msg)
+			assert.Equal(content, string(res), v.msg+" "+name)
+		}
+		assert.Equal(v.skipped, report.Skipped, v.msg)
+		assert.Equal(v.renamed, report.Renamed, v.msg)
+	}
+
+	err := gnsys.ExtractTar(path, existing(), gnsys.OptOverwrite(gnsys.FailExisting))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	assert.Contains(err.Error(), "already exists")
+
+	// zip entries are renamed by parallel workers too
+	var entries []testEntry
+	for i := range 50 {
+		entries = append(entries, testEntry{
+			name: fmt.Sprintf("data/f%d.txt", i), body: "new",
+		})
+	}
+	zipPath := makeZip(t, entries)
+	dstDir := t.TempDir()
+	err = gnsys.ExtractZip(zipPath, dstDir)
+	assert.Nil(err)
+	var report gnsys.ExtractReport
+	err = gnsys.ExtractZip(zipPath, dstDir, gnsys.OptWorkers(4),
+		gnsys.OptOverwrite(gnsys.RenameNew), gnsys.OptReport(&report))
+	assert.Nil(err)
+	assert.Len(report.Renamed, 50)
+	assert.True(gnsys.IsFile(filepath.Join(dstDir, "data", "f49.1.txt")))
+
+	// single compressed files
+	gzPath := filepath.Join("testdata", "text.txt.gz")
+	dstDir = t.TempDir()
+	err = os.WriteFile(filepath.Join(dstDir, "text.txt"), []byte("old"), 0644)
+	assert.Nil(err)
+	report = gnsys.ExtractReport{}
+	err = gnsys.ExtractGz(gzPath, dstDir,
+		gnsys.OptOverwrite(gnsys.SkipExisting), gnsys.OptReport(&report))
+	assert.Nil(err)
+	dstPath := filepath.Join(dstDir, "text.txt")
+	assert.Equal([]string{dstPath}, report.Skipped)
+	err = gnsys.ExtractGz(gzPath, dstDir,
+		gnsys.OptOverwrite(gnsys.RenameNew), gnsys.OptReport(&report))
+	assert.Nil(err)
+	assert.Equal(filepath.Join(dstDir, "text.1.txt"), report.Renamed[dstPath])
+	assert.True(gnsys.IsFile(filepath.Join(dstDir, "text.1.txt")))
+}
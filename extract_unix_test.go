@@ -0,0 +1,47 @@
+//go:build unix
+
+package gnsys_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/gnames/gnsys"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExtractZipDescriptors makes sure that extraction does not keep files
+// of extracted entries open. The archive has more entries than both
+// the usual default limit of open files (1024) and the lowered limit.
+func TestExtractZipDescriptors(t *testing.T) {
+	assert := assert.New(t)
+	entries := make([]testEntry, 1100)
+	for i := range entries {
+		entries[i] = testEntry{
+			name: fmt.Sprintf("data/f%04d.txt", i),
+			body: "content",
+		}
+	}
+	path := makeZip(t, entries)
+
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	assert.Nil(err)
+	lowered := syscall.Rlimit{Cur: 128, Max: limit.Max}
+	if err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skipf("cannot lower the limit of open files: %v", err)
+	}
+	t.Cleanup(func() {
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+	})
+
+	for _, workers := range []int{0, 8} {
+		dstDir := t.TempDir()
+		err = gnsys.ExtractZip(path, dstDir, gnsys.OptWorkers(workers))
+		assert.Nil(err, workers)
+		last := filepath.Join(dstDir, "data", "f1099.txt")
+		assert.True(gnsys.IsFile(last), workers)
+	}
+}
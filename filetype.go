@@ -1,7 +1,13 @@
 package gnsys
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
 	"strings"
+	"unicode/utf8"
 )
 
 type FileType int
@@ -18,6 +24,14 @@ const (
 	XzFT               // .xz
 	SqlFT              // .sql
 	SqliteFT           // .sqlite
+	TextFT             // plain text, detected by content only
+	ZstFT              // .zst
+	TarZstFT           // .tar.zst
+	Lz4FT              // .lz4
+	TarLz4FT           // .tar.lz4
+	BrFT               // .br
+	ZFT                // .Z
+	TarZFT             // .tar.Z
 )
 
 var ftMap = map[FileType]string{
@@ -32,6 +46,14 @@ var ftMap = map[FileType]string{
 	XzFT:      "xz",
 	SqlFT:     "sql",
 	SqliteFT:  "sqlite",
+	TextFT:    "text",
+	ZstFT:     "zst",
+	TarZstFT:  "tar-zst",
+	Lz4FT:     "lz4",
+	TarLz4FT:  "tar-lz4",
+	BrFT:      "br",
+	ZFT:       "Z",
+	TarZFT:    "tar-Z",
 }
 
 func (ft FileType) String() string {
@@ -56,6 +78,20 @@ func GetFileType(file string) FileType {
 		return TarBzFT
 	case strings.HasSuffix(file, ".bz2"):
 		return Bz2FT
+	case strings.HasSuffix(file, ".tar.zst"):
+		return TarZstFT
+	case strings.HasSuffix(file, ".zst"):
+		return ZstFT
+	case strings.HasSuffix(file, ".tar.lz4"):
+		return TarLz4FT
+	case strings.HasSuffix(file, ".lz4"):
+		return Lz4FT
+	case strings.HasSuffix(file, ".br"):
+		return BrFT
+	case strings.HasSuffix(file, ".tar.Z"), strings.HasSuffix(file, ".taZ"):
+		return TarZFT
+	case strings.HasSuffix(file, ".Z"):
+		return ZFT
 	case strings.HasSuffix(file, ".sql"):
 		return SqlFT
 	case strings.HasSuffix(file, ".sqlite"):
@@ -64,3 +100,128 @@ func GetFileType(file string) FileType {
 		return UnknownFT
 	}
 }
+
+// sniffSize is the amount of data read to detect file type. Compressed
+// payloads are partially decompressed from it to recognize tar archives,
+// so it has to hold a whole bzip2 block.
+const sniffSize = 1 << 20
+
+// magic numbers of the detected formats.
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	gzMagic       = []byte{0x1f, 0x8b}
+	bz2Magic      = []byte("BZh")
+	xzMagic       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstMagic      = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic      = []byte{0x04, 0x22, 0x4d, 0x18}
+	zMagic        = []byte{0x1f, 0x9d}
+	sqliteMagic   = []byte("SQLite format 3\x00")
+	tarMagic      = []byte("ustar")
+)
+
+// tarMagicOffset is the position of "ustar" magic in a tar header.
+const tarMagicOffset = 257
+
+// DetectFileType determines the type of a file by its content rather than
+// its name. It recognizes zip, gzip, bzip2, xz, zstd, lz4, compress (.Z),
+// tar, SQLite and plain text.
+// For compressed files it peeks inside the stream, so a gzip-compressed
+// tarball is reported as TarGzFT regardless of its extension.
+// If the content is not recognized, it returns UnknownFT.
+func DetectFileType(path string) (FileType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return UnknownFT, err
+	}
+	defer f.Close()
+	return DetectFileTypeReader(f)
+}
+
+// DetectFileTypeReader determines the type of data read from r the same
+// way as DetectFileType. It consumes up to 1 MiB of the reader.
+func DetectFileTypeReader(r io.Reader) (FileType, error) {
+	return detectFileType(bufio.NewReaderSize(r, sniffSize))
+}
+
+// detectFileType determines the type of data without consuming it
+// from the reader.
+func detectFileType(br *bufio.Reader) (FileType, error) {
+	head, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return UnknownFT, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, zipMagic), bytes.HasPrefix(head, zipEmptyMagic):
+		return ZipFT, nil
+	case bytes.HasPrefix(head, gzMagic):
+		return compressedType(head, GzFT, TarGzFT), nil
+	case bytes.HasPrefix(head, bz2Magic) && len(head) > 3 &&
+		head[3] >= '1' && head[3] <= '9':
+		return compressedType(head, Bz2FT, TarBzFT), nil
+	case bytes.HasPrefix(head, xzMagic):
+		return compressedType(head, XzFT, TarXzFt), nil
+	case bytes.HasPrefix(head, zstMagic):
+		return compressedType(head, ZstFT, TarZstFT), nil
+	case bytes.HasPrefix(head, lz4Magic):
+		return compressedType(head, Lz4FT, TarLz4FT), nil
+	case bytes.HasPrefix(head, zMagic):
+		return compressedType(head, ZFT, TarZFT), nil
+	case bytes.HasPrefix(head, sqliteMagic):
+		return SqliteFT, nil
+	case isTarHeader(head):
+		return TarFT, nil
+	case looksLikeText(head):
+		return TextFT, nil
+	}
+	return UnknownFT, nil
+}
+
+// compressedType decompresses the beginning of a compressed stream and
+// returns tarFT if it contains a tar archive, or ft otherwise.
+func compressedType(head []byte, ft, tarFT FileType) FileType {
+	rc, err := newDecompressor(bytes.NewReader(head), ft)
+	if err != nil {
+		return ft
+	}
+	defer rc.Close()
+
+	buf := make([]byte, tarMagicOffset+len(tarMagic))
+	if _, err = io.ReadFull(rc, buf); err != nil {
+		return ft
+	}
+	if isTarHeader(buf) {
+		return tarFT
+	}
+	return ft
+}
+
+// isTarHeader checks for POSIX ustar or GNU tar magic.
+func isTarHeader(head []byte) bool {
+	end := tarMagicOffset + len(tarMagic)
+	return len(head) >= end && bytes.Equal(head[tarMagicOffset:end], tarMagic)
+}
+
+// looksLikeText checks if the beginning of data is UTF-8 text without
+// control characters other than whitespace.
+func looksLikeText(head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+	head = head[:min(len(head), 4096)]
+	// Do not penalize a multibyte character cut at the end.
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if !utf8.Valid(head) {
+		return false
+	}
+
+	for _, b := range head {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package gnsys_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gnames/gnsys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFileType(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		file string
+		ft   gnsys.FileType
+	}{
+		{"a.zip", gnsys.ZipFT},
+		{"a.tar", gnsys.TarFT},
+		{"a.tar.gz", gnsys.TarGzFT},
+		{"a.gz", gnsys.GzFT},
+		{"a.tar.xz", gnsys.TarXzFt},
+		{"a.xz", gnsys.XzFT},
+		{"a.tar.bz2", gnsys.TarBzFT},
+		{"a.bz2", gnsys.Bz2FT},
+		{"a.tar.zst", gnsys.TarZstFT},
+		{"a.zst", gnsys.ZstFT},
+		{"a.tar.lz4", gnsys.TarLz4FT},
+		{"a.lz4", gnsys.Lz4FT},
+		{"a.br", gnsys.BrFT},
+		{"a.tar.Z", gnsys.TarZFT},
+		{"a.Z", gnsys.ZFT},
+		{"a.sql", gnsys.SqlFT},
+		{"a.sqlite", gnsys.SqliteFT},
+		{"a.txt", gnsys.UnknownFT},
+	}
+
+	for _, v := range tests {
+		assert.Equal(v.ft, gnsys.GetFileType(v.file), v.file)
+	}
+}
+
+func TestDetectFileType(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		file string
+		ft   gnsys.FileType
+	}{
+		{"data.zip", gnsys.ZipFT},
+		{"data.tar.gz", gnsys.TarGzFT},
+		{"data.tar.bz2", gnsys.TarBzFT},
+		{"data.tar.xz", gnsys.TarXzFt},
+		{"text.txt.gz", gnsys.GzFT},
+		{"text.txt.bz2", gnsys.Bz2FT},
+		{"text.txt.xz", gnsys.XzFT},
+		{"data.tar.zst", gnsys.TarZstFT},
+		{"text.txt.zst", gnsys.ZstFT},
+		{"data.tar.lz4", gnsys.TarLz4FT},
+		{"text.txt.lz4", gnsys.Lz4FT},
+		{"data.tar.Z", gnsys.TarZFT},
+		{"text.txt.Z", gnsys.ZFT},
+		{"names.txt.Z", gnsys.ZFT},
+		{"text.txt", gnsys.TextFT},
+	}
+
+	dir := t.TempDir()
+	for _, v := range tests {
+		ft, err := gnsys.DetectFileType(filepath.Join("testdata", v.file))
+		assert.Nil(err, v.file)
+		assert.Equal(v.ft, ft, v.file)
+
+		// names without extensions or with wrong ones do not matter
+		content, err := os.ReadFile(filepath.Join("testdata", v.file))
+		assert.Nil(err)
+		path := filepath.Join(dir, "misnamed.zip")
+		err = os.WriteFile(path, content, 0644)
+		assert.Nil(err)
+		ft, err = gnsys.DetectFileType(path)
+		assert.Nil(err, v.file)
+		assert.Equal(v.ft, ft, v.file)
+	}
+
+	ft, err := gnsys.DetectFileType(makeTar(t, []testEntry{{name: "a", body: "a"}}))
+	assert.Nil(err)
+	assert.Equal(gnsys.TarFT, ft)
+
+	sqlite := append([]byte("SQLite format 3\x00"), make([]byte, 100)...)
+	ft, err = gnsys.DetectFileTypeReader(bytes.NewReader(sqlite))
+	assert.Nil(err)
+	assert.Equal(gnsys.SqliteFT, ft)
+
+	ft, err = gnsys.DetectFileTypeReader(bytes.NewReader([]byte{0, 1, 2, 3}))
+	assert.Nil(err)
+	assert.Equal(gnsys.UnknownFT, ft)
+
+	_, err = gnsys.DetectFileType(filepath.Join("testdata", "no-such-file"))
+	assert.NotNil(err)
+}
@@ -0,0 +1,100 @@
+package gnsys
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// entryFilter selects archive entries to extract.
+type entryFilter struct {
+	// include and exclude are glob patterns.
+	include, exclude []string
+
+	// includeRe and excludeRe are regular expressions.
+	includeRe, excludeRe []*regexp.Regexp
+
+	// entries are explicitly requested entry names.
+	entries map[string]bool
+}
+
+// active checks if the filter limits extracted entries.
+func (f *entryFilter) active() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0 ||
+		len(f.includeRe) > 0 || len(f.excludeRe) > 0 || len(f.entries) > 0
+}
+
+// onlyEntries checks if the filter selects nothing but explicitly
+// requested entries, so extraction can stop when all of them are found.
+func (f *entryFilter) onlyEntries() bool {
+	return len(f.entries) > 0 && len(f.include) == 0 && len(f.includeRe) == 0
+}
+
+// selects checks if an entry has to be extracted. The name is a clean
+// slash-separated relative path.
+func (f *entryFilter) selects(name string) bool {
+	if matchPath(f.exclude, name) || matchRegexp(f.excludeRe, name) {
+		return false
+	}
+	if len(f.include) == 0 && len(f.includeRe) == 0 && len(f.entries) == 0 {
+		return true
+	}
+	return f.entries[name] || matchPath(f.include, name) ||
+		matchRegexp(f.includeRe, name)
+}
+
+// cleanEntryName converts an entry name to the form used by filters.
+func cleanEntryName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// checkPatterns verifies the syntax of glob patterns.
+func checkPatterns(patterns []string) error {
+	for _, v := range patterns {
+		if _, err := path.Match(v, ""); err != nil {
+			return fmt.Errorf("pattern '%s': %w", v, err)
+		}
+	}
+	return nil
+}
+
+// matchName checks if a slash-separated name or its base name matches one
+// of the glob patterns.
+func matchName(patterns []string, name string) bool {
+	base := path.Base(name)
+	for _, v := range patterns {
+		if ok, _ := path.Match(v, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(v, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath checks if a name or any of its parent directories matches one
+// of the glob patterns, so a pattern that matches a directory applies to
+// all its content.
+func matchPath(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for ; name != "." && name != "/"; name = path.Dir(name) {
+		if matchName(patterns, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRegexp checks if a name matches one of the regular expressions.
+func matchRegexp(res []*regexp.Regexp, name string) bool {
+	for _, v := range res {
+		if v.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,19 @@
 package gnsys_test
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gnames/gnsys"
 	"github.com/stretchr/testify/assert"
@@ -231,3 +240,153 @@ func TestIsTextFile(t *testing.T) {
 		assert.Equal(v.isText, isText)
 	}
 }
+
+func TestDownloadConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	content := []byte("shared reference file")
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			time.Sleep(300 * time.Millisecond)
+			w.Write(content)
+		},
+	))
+	defer srv.Close()
+
+	destDir := t.TempDir()
+	var wg sync.WaitGroup
+	paths := make([]string, 5)
+	errs := make([]error, 5)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = gnsys.Download(srv.URL+"/ref.txt", destDir, false)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(int32(1), calls.Load())
+	for i := range paths {
+		assert.Nil(errs[i])
+		assert.Equal(filepath.Join(destDir, "ref.txt"), paths[i])
+	}
+	res, err := os.ReadFile(filepath.Join(destDir, "ref.txt"))
+	assert.Nil(err)
+	assert.Equal(content, res)
+
+	// waiting calls verify their own checksums
+	sum := sha256.Sum256(content)
+	sums := []string{"", strings.Repeat("0", 64), hex.EncodeToString(sum[:])}
+	destDir = t.TempDir()
+	calls.Store(0)
+	errs = make([]error, len(sums))
+	for i, v := range sums {
+		var opts []gnsys.DownloadOption
+		if v != "" {
+			opts = append(opts, gnsys.OptSHA256(v))
+		}
+		wg.Go(func() {
+			_, errs[i] = gnsys.Download(srv.URL+"/ref.txt", destDir, false, opts...)
+		})
+		// the call without a checksum fetches the file
+		if i == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(int32(1), calls.Load())
+	assert.Nil(errs[0])
+	assert.IsType(&gnsys.ErrDownload{}, errs[1])
+	assert.Nil(errs[2])
+}
+
+func TestDownloadExtract(t *testing.T) {
+	assert := assert.New(t)
+	archive, err := os.ReadFile(filepath.Join("testdata", "data.tar.gz"))
+	assert.Nil(err)
+	sum := sha256.Sum256(archive)
+	goodSum := hex.EncodeToString(sum[:])
+
+	// Old tar archives have no magic, only Content-Type tells what they are.
+	v7, err := os.ReadFile(makeTar(t, []testEntry{
+		{name: "v7/a.txt", body: "seven"},
+	}))
+	assert.Nil(err)
+	clear(v7[257:265])
+	copy(v7[148:156], "        ")
+	var chksum int
+	for _, b := range v7[:512] {
+		chksum += int(b)
+	}
+	copy(v7[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+	ft, err := gnsys.DetectFileTypeReader(bytes.NewReader(v7))
+	assert.Nil(err)
+	assert.Equal(gnsys.UnknownFT, ft)
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v7" {
+				w.Header().Set("Content-Type", "application/x-tar")
+				w.Write(v7)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-gtar")
+			w.Write(archive)
+		},
+	))
+	defer srv.Close()
+
+	tests := []struct {
+		msg, url, sum, path string
+		isErr               bool
+	}{
+		{"by name", srv.URL + "/data.tar.gz", "", "data/sub/c.txt", false},
+		{"by content", srv.URL + "/latest", "", "data/sub/c.txt", false},
+		{"by content type", srv.URL + "/v7", "", "v7/a.txt", false},
+		{"checksum", srv.URL + "/data.tar.gz", goodSum, "data/sub/c.txt", false},
+		{"bad checksum", srv.URL + "/data.tar.gz", strings.Repeat("0", 64), "", true},
+	}
+
+	for _, v := range tests {
+		parent := t.TempDir()
+		dstDir := filepath.Join(parent, "out")
+		var opts []gnsys.DownloadOption
+		if v.sum != "" {
+			opts = append(opts, gnsys.OptSHA256(v.sum))
+		}
+		err := gnsys.DownloadExtract(v.url, dstDir, false, opts...)
+		assert.Equal(v.isErr, err != nil, v.msg)
+		if v.isErr {
+			assert.IsType(&gnsys.ErrDownload{}, err, v.msg)
+			// nothing is extracted and no staging directory is left
+			assert.Equal(gnsys.DirEmpty, gnsys.GetDirState(parent), v.msg)
+			continue
+		}
+		assert.True(gnsys.IsFile(filepath.Join(dstDir, v.path)), v.msg)
+	}
+
+	// options of the extraction
+	dstDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dstDir, "a.txt"), []byte("old"), 0644)
+	assert.Nil(err)
+	var report gnsys.ExtractReport
+	err = gnsys.DownloadExtract(srv.URL+"/data.tar.gz", dstDir, false,
+		gnsys.OptSHA256(goodSum),
+		gnsys.OptExtract(
+			gnsys.OptStripComponents(1),
+			gnsys.OptOverwrite(gnsys.SkipExisting),
+			gnsys.OptReport(&report),
+		),
+	)
+	assert.Nil(err)
+	assert.Equal([]string{"data/a.txt"}, report.Skipped)
+	res, err := os.ReadFile(filepath.Join(dstDir, "a.txt"))
+	assert.Nil(err)
+	assert.Equal("old", string(res))
+	res, err = os.ReadFile(filepath.Join(dstDir, "sub", "c.txt"))
+	assert.Nil(err)
+	assert.Equal("gamma\n", string(res))
+}
@@ -3,9 +3,15 @@ module github.com/gnames/gnsys
 go 1.25
 
 require (
+	github.com/andybalholm/brotli v1.2.6
 	github.com/cheggaaa/pb/v3 v3.1.7
-	github.com/stretchr/testify v1.9.0
+	github.com/dsnet/compress v0.0.1
+	github.com/klauspost/compress v1.20.1
+	github.com/pierrec/lz4/v4 v4.1.33
+	github.com/pkg/sftp v1.13.10
+	github.com/stretchr/testify v1.10.0
 	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.48.0
 )
 
 require (
@@ -14,10 +20,11 @@ require (
 	github.com/clipperhouse/uax29/v2 v2.3.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fatih/color v1.18.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.19 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.40.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
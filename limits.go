@@ -0,0 +1,143 @@
+package gnsys
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ratioMinSize is the amount of uncompressed data that has to be written
+// before the compression ratio is checked. Small files of repetitive
+// content compress extremely well and would exceed the ratio otherwise.
+const ratioMinSize = 1 << 20
+
+// limits protect extraction from decompression bombs. Zero values mean
+// no limit.
+type limits struct {
+	// maxTotal is the maximum size of all extracted data.
+	maxTotal int64
+
+	// maxEntry is the maximum size of one extracted file.
+	maxEntry int64
+
+	// maxEntries is the maximum number of extracted entries of an archive.
+	maxEntries int
+
+	// maxRatio is the maximum ratio of uncompressed to compressed size.
+	maxRatio float64
+}
+
+// limiter enforces limits during one extraction.
+type limiter struct {
+	limits
+
+	// src is the archive path or name used in error messages.
+	src string
+
+	// compressed counts compressed bytes consumed from the source. It is
+	// nil if the source is not a stream (zip).
+	compressed *countReader
+
+	// total is the size of data written so far. Entries of zip archives
+	// can be written concurrently.
+	total atomic.Int64
+
+	// entries is the number of entries seen so far.
+	entries int
+}
+
+// newLimiter creates a limiter for an extraction of src.
+func newLimiter(cfg *extractConfig, src string, compressed *countReader) *limiter {
+	return &limiter{limits: cfg.limits, src: src, compressed: compressed}
+}
+
+// err creates an error about the exceeded limit.
+func (l *limiter) err(entry, limit string) error {
+	return &ErrLimit{Path: l.src, Entry: entry, Limit: limit}
+}
+
+// addEntry counts an archive entry.
+func (l *limiter) addEntry(entry string) error {
+	l.entries++
+	if l.maxEntries > 0 && l.entries > l.maxEntries {
+		return l.err(entry, "entries")
+	}
+	return nil
+}
+
+// checkDeclared verifies sizes recorded in an archive before the entry is
+// extracted. The packed size is zero if it is unknown.
+func (l *limiter) checkDeclared(entry string, size, packed int64) error {
+	switch {
+	case l.maxEntry > 0 && size > l.maxEntry:
+		return l.err(entry, "entry size")
+	case l.maxRatio > 0 && packed > 0 && size > ratioMinSize &&
+		float64(size)/float64(packed) > l.maxRatio:
+		return l.err(entry, "compression ratio")
+	}
+	return nil
+}
+
+// writer wraps w to enforce limits while an entry is written. The packed
+// size is the compressed size of the entry, or zero if it is unknown.
+// Sizes are counted as data is written, because sizes declared by
+// an archive cannot be trusted.
+func (l *limiter) writer(w io.Writer, entry string, packed int64) io.Writer {
+	return &limitWriter{w: w, l: l, entry: entry, packed: packed}
+}
+
+// limitWriter counts written data and fails once a limit is exceeded.
+type limitWriter struct {
+	w      io.Writer
+	l      *limiter
+	entry  string
+	packed int64
+	n      int64
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	l := lw.l
+	n := int64(len(p))
+	if l.maxEntry > 0 && lw.n+n > l.maxEntry {
+		return 0, l.err(lw.entry, "entry size")
+	}
+	lw.n += n
+	total := l.total.Add(n)
+	if l.maxTotal > 0 && total > l.maxTotal {
+		return 0, l.err(lw.entry, "total size")
+	}
+	if lw.ratioExceeded(total) {
+		return 0, l.err(lw.entry, "compression ratio")
+	}
+	return lw.w.Write(p)
+}
+
+// ratioExceeded compares written data to the compressed size of the entry,
+// if it is known, or the total of written data to the compressed data
+// consumed from the stream.
+func (lw *limitWriter) ratioExceeded(total int64) bool {
+	l := lw.l
+	if l.maxRatio <= 0 {
+		return false
+	}
+	if lw.packed > 0 {
+		return lw.n > ratioMinSize &&
+			float64(lw.n)/float64(lw.packed) > l.maxRatio
+	}
+	if l.compressed != nil {
+		return total > ratioMinSize &&
+			float64(total)/float64(max(l.compressed.n, 1)) > l.maxRatio
+	}
+	return false
+}
+
+// countReader counts bytes read from the underlying reader.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,131 @@
+package gnsys_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gnames/gnsys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractLimits(t *testing.T) {
+	assert := assert.New(t)
+	zeros := strings.Repeat("\x00", 2<<20)
+
+	tarPath := makeTar(t, []testEntry{
+		{name: "bomb/", typ: '5', mode: 0755},
+		{name: "bomb/a.bin", body: zeros},
+		{name: "bomb/b.bin", body: zeros},
+		{name: "bomb/c.txt", body: "small"},
+	})
+	err := gnsys.CompressGz(tarPath, filepath.Dir(tarPath))
+	assert.Nil(err)
+	gzPath := tarPath + ".gz"
+
+	srcDir := filepath.Join(t.TempDir(), "bomb")
+	err = os.MkdirAll(srcDir, 0755)
+	assert.Nil(err)
+	err = os.WriteFile(filepath.Join(srcDir, "a.bin"), []byte(zeros), 0644)
+	assert.Nil(err)
+	zipPath := filepath.Join(t.TempDir(), "bomb.zip")
+	err = gnsys.CreateZip([]string{srcDir}, zipPath)
+	assert.Nil(err)
+
+	tests := []struct {
+		msg, path, limit string
+		opt              gnsys.ExtractOption
+	}{
+		{"tar total", tarPath, "total size", gnsys.OptMaxTotalSize(3 << 20)},
+		{"gz total", gzPath, "total size", gnsys.OptMaxTotalSize(3 << 20)},
+		{"gz entry", gzPath, "entry size", gnsys.OptMaxEntrySize(1 << 20)},
+		{"gz entries", gzPath, "entries", gnsys.OptMaxEntries(3)},
+		{"gz ratio", gzPath, "compression ratio", gnsys.OptMaxRatio(50)},
+		{"zip total", zipPath, "total size", gnsys.OptMaxTotalSize(1 << 20)},
+		{"zip entry", zipPath, "entry size", gnsys.OptMaxEntrySize(1 << 20)},
+		{"zip entries", zipPath, "entries", gnsys.OptMaxEntries(1)},
+		{"zip ratio", zipPath, "compression ratio", gnsys.OptMaxRatio(50)},
+	}
+	for _, v := range tests {
+		// the directory created by the extraction is removed
+		dstDir := filepath.Join(t.TempDir(), "out")
+		err = gnsys.Extract(v.path, dstDir, v.opt)
+		var errLimit *gnsys.ErrLimit
+		if assert.True(errors.As(err, &errLimit), v.msg) {
+			assert.Equal(v.limit, errLimit.Limit, v.msg)
+		}
+		assert.False(gnsys.IsDir(dstDir), v.msg)
+
+		// an existing directory is kept, but extracted files are removed
+		dstDir = t.TempDir()
+		err = gnsys.Extract(v.path, dstDir, v.opt)
+		assert.IsType(&gnsys.ErrLimit{}, err, v.msg)
+		assert.Equal(gnsys.DirEmpty, gnsys.GetDirState(dstDir), v.msg)
+	}
+
+	// files that existed before are kept, even if they were overwritten
+	dstDir := t.TempDir()
+	oldPath := filepath.Join(dstDir, "bomb", "a.bin")
+	err = os.MkdirAll(filepath.Dir(oldPath), 0755)
+	assert.Nil(err)
+	err = os.WriteFile(oldPath, []byte("old"), 0644)
+	assert.Nil(err)
+	err = gnsys.Extract(tarPath, dstDir, gnsys.OptMaxTotalSize(3<<20))
+	assert.IsType(&gnsys.ErrLimit{}, err)
+	assert.True(gnsys.IsFile(oldPath))
+	assert.False(gnsys.IsFile(filepath.Join(dstDir, "bomb", "b.bin")))
+
+	// an existing result of a single compressed file is kept too
+	bigPath := filepath.Join(t.TempDir(), "big.txt")
+	err = os.WriteFile(bigPath, []byte(zeros), 0644)
+	assert.Nil(err)
+	err = gnsys.CompressGz(bigPath, filepath.Dir(bigPath))
+	assert.Nil(err)
+	dstDir = t.TempDir()
+	oldPath = filepath.Join(dstDir, "big.txt")
+	err = os.WriteFile(oldPath, []byte("precious"), 0644)
+	assert.Nil(err)
+	err = gnsys.ExtractGz(bigPath+".gz", dstDir, gnsys.OptMaxEntrySize(100))
+	assert.IsType(&gnsys.ErrLimit{}, err)
+	content, err := os.ReadFile(oldPath)
+	assert.Nil(err)
+	assert.Equal("precious", string(content))
+	entries, err := os.ReadDir(dstDir)
+	assert.Nil(err)
+	assert.Len(entries, 1)
+
+	// entries excluded by filters do not count
+	for _, path := range []string{tarPath, gzPath, zipPath} {
+		dstDir = t.TempDir()
+		err = gnsys.Extract(path, dstDir,
+			gnsys.OptExclude("*.bin"),
+			gnsys.OptMaxTotalSize(1<<20),
+			gnsys.OptMaxEntries(2),
+		)
+		assert.Nil(err, path)
+		assert.False(gnsys.IsFile(filepath.Join(dstDir, "bomb", "a.bin")), path)
+	}
+
+	dstDir = t.TempDir()
+	err = gnsys.Extract(gzPath, dstDir,
+		gnsys.OptMaxTotalSize(5<<20),
+		gnsys.OptMaxEntrySize(2<<20),
+		gnsys.OptMaxEntries(4),
+		gnsys.OptMaxRatio(1000),
+	)
+	assert.Nil(err)
+	assert.True(gnsys.IsFile(filepath.Join(dstDir, "bomb", "b.bin")))
+
+	// single-file extraction
+	binPath := filepath.Join(srcDir, "a.bin")
+	err = gnsys.CompressGz(binPath, srcDir)
+	assert.Nil(err)
+	dstDir = t.TempDir()
+	err = gnsys.ExtractGz(binPath+".gz", dstDir, gnsys.OptMaxRatio(50))
+	assert.IsType(&gnsys.ErrLimit{}, err)
+	assert.False(gnsys.IsFile(filepath.Join(dstDir, "a.bin")))
+	err = gnsys.ExtractGz(binPath+".gz", dstDir, gnsys.OptMaxEntrySize(2<<20))
+	assert.Nil(err)
+}
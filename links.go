@@ -0,0 +1,105 @@
+package gnsys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxLinkHops is the number of symbolic links followed while a target of
+// a new link is resolved, like MAXSYMLINKS of Linux.
+const maxLinkHops = 40
+
+// linkFS gives access to files that are already in place when a symbolic
+// link is checked. *os.Root implements it.
+type linkFS interface {
+	Lstat(name string) (os.FileInfo, error)
+	Readlink(name string) (string, error)
+}
+
+// checkSymlink verifies that a symbolic link stored at name points inside
+// the root of fsys. Both name and the target are relative to the root.
+// The target is resolved through the links that already exist, so a chain
+// of links cannot lead outside either. Links, files and missing paths on
+// the way might be replaced with other links later, which would change
+// where the rest of the target leads, so the target may go up with ".."
+// only out of real directories reached without them. Links that pass this
+// check keep pointing inside the root whatever is extracted after them.
+// The root is called where in error messages.
+func checkSymlink(fsys linkFS, name, target, where string) error {
+	if target == "" || filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return fmt.Errorf("symlink target '%s' is not allowed", target)
+	}
+	outside := fmt.Errorf("symlink target '%s' is outside of '%s'", target, where)
+
+	dir := filepath.Dir(filepath.FromSlash(name))
+	todo := append(pathParts(dir, false), pathParts(target, true)...)
+	var cur []string
+	var hops int
+	// unstable is true once the path went through anything but a real
+	// directory.
+	var unstable bool
+	for len(todo) > 0 {
+		part := todo[0]
+		todo = todo[1:]
+		switch {
+		case part.name == "" || part.name == ".":
+			continue
+		case part.name == ".." && part.own && unstable:
+			return fmt.Errorf(
+				"symlink target '%s' goes up through a symbolic link "+
+					"or a path that is not a directory", target,
+			)
+		case part.name == "..":
+			// Links met on the way were checked when they were created.
+			if len(cur) == 0 {
+				return outside
+			}
+			cur = cur[:len(cur)-1]
+			continue
+		}
+
+		path := filepath.Join(append(cur, part.name)...)
+		fi, err := fsys.Lstat(path)
+		switch {
+		case err == nil && fi.Mode()&os.ModeSymlink != 0:
+			link, err := fsys.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("symlink target '%s': %w", target, err)
+			}
+			if link == "" || filepath.IsAbs(link) || strings.HasPrefix(link, "/") {
+				return outside
+			}
+			if hops++; hops > maxLinkHops {
+				return fmt.Errorf(
+					"symlink target '%s' has too many levels of links", target,
+				)
+			}
+			unstable = true
+			todo = append(pathParts(link, false), todo...)
+			continue
+		case err != nil || !fi.IsDir():
+			unstable = true
+		}
+		cur = append(cur, part.name)
+	}
+	return nil
+}
+
+// pathPart is a component of a path resolved by checkSymlink. Own parts
+// belong to the checked target, the rest to its location or to links on
+// the way.
+type pathPart struct {
+	name string
+	own  bool
+}
+
+// pathParts splits a path into its components.
+func pathParts(path string, own bool) []pathPart {
+	var res []pathPart
+	for _, v := range strings.Split(filepath.ToSlash(path), "/") {
+		res = append(res, pathPart{name: v, own: own})
+	}
+	return res
+}
@@ -0,0 +1,217 @@
+package gnsys
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// EntryType is the kind of a file stored in an archive.
+type EntryType int
+
+const (
+	RegularEntry EntryType = iota
+	DirEntry
+	SymlinkEntry
+	HardlinkEntry
+	OtherEntry
+)
+
+// String returns a string representation of the EntryType.
+func (t EntryType) String() string {
+	switch t {
+	case RegularEntry:
+		return "file"
+	case DirEntry:
+		return "dir"
+	case SymlinkEntry:
+		return "symlink"
+	case HardlinkEntry:
+		return "hardlink"
+	}
+	return "other"
+}
+
+// ArchiveEntry describes a file stored in an archive.
+type ArchiveEntry struct {
+	// Name is the name of the entry as it is stored in the archive.
+	Name string
+
+	// Type is the kind of the entry.
+	Type EntryType
+
+	// Size is the uncompressed size of the entry in bytes.
+	Size int64
+
+	// CompressedSize is the size of the entry inside the archive. Entries
+	// of compressed tar archives are compressed together, so their
+	// compressed size is unknown and set to -1.
+	CompressedSize int64
+
+	// Mode contains permissions and type bits of the entry.
+	Mode os.FileMode
+
+	// ModTime is the modification time of the entry.
+	ModTime time.Time
+
+	// Link is the target of a symbolic or hard link.
+	Link string
+}
+
+// ArchiveInfo describes the content of an archive.
+type ArchiveInfo struct {
+	// FileType is the type of the archive.
+	FileType FileType
+
+	// Entries are the files of the archive in the order they are stored.
+	Entries []ArchiveEntry
+
+	// TotalSize is the sum of uncompressed sizes of all entries, the disk
+	// space the extraction needs.
+	TotalSize int64
+}
+
+// ListArchive reads the content of a zip or tar archive (plain or
+// compressed) located at path without extracting it. The type of the
+// archive is detected the same way as in Extract. Compressed tar archives
+// are decompressed to reach every entry, so listing them takes about
+// as long as extraction.
+func ListArchive(path string) (*ArchiveInfo, error) {
+	exists, _ := FileExists(path)
+	if !exists {
+		return nil, &ErrFileMissing{Path: path}
+	}
+
+	ft, err := DetectFileType(path)
+	if err != nil {
+		return nil, &ErrExtract{Path: path, Err: err}
+	}
+	if ft == UnknownFT {
+		ft = GetFileType(path)
+	}
+
+	switch ft {
+	case ZipFT:
+		return listZip(path)
+	case TarFT, TarGzFT, TarBzFT, TarXzFt, TarZstFT, TarLz4FT, TarZFT:
+		return listTar(path, ft)
+	default:
+		err = fmt.Errorf("cannot list file type '%s'", ft)
+		return nil, &ErrExtract{Path: path, Err: err}
+	}
+}
+
+// listZip reads the central directory of a zip archive.
+func listZip(path string) (*ArchiveInfo, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, &ErrExtract{Path: path, Err: err}
+	}
+	defer r.Close()
+
+	res := &ArchiveInfo{FileType: ZipFT}
+	for _, f := range r.File {
+		entry := ArchiveEntry{
+			Name:           f.Name,
+			Type:           modeEntryType(f.Mode()),
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Mode:           f.Mode(),
+			ModTime:        f.Modified,
+		}
+		if entry.Type == SymlinkEntry {
+			if entry.Link, err = zipLink(f); err != nil {
+				return nil, &ErrExtract{Path: path, Entry: f.Name, Err: err}
+			}
+		}
+		res.add(entry)
+	}
+	return res, nil
+}
+
+// zipLink reads the target of a symbolic link stored in a zip entry.
+func zipLink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	res, err := io.ReadAll(io.LimitReader(rc, maxLinkLen))
+	return string(res), err
+}
+
+// listTar reads headers of all entries of a tar archive.
+func listTar(path string, ft FileType) (*ArchiveInfo, error) {
+	reader, cleanup, err := openDecompressor(path, ft)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	res := &ArchiveInfo{FileType: ft}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ErrExtract{Path: path, Err: err}
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		entry := ArchiveEntry{
+			Name:           header.Name,
+			Type:           tarEntryType(header.Typeflag),
+			Size:           header.Size,
+			CompressedSize: -1,
+			Mode:           header.FileInfo().Mode(),
+			ModTime:        header.ModTime,
+			Link:           header.Linkname,
+		}
+		if ft == TarFT {
+			entry.CompressedSize = header.Size
+		}
+		res.add(entry)
+	}
+	return res, nil
+}
+
+// add appends an entry and counts its size.
+func (a *ArchiveInfo) add(entry ArchiveEntry) {
+	a.Entries = append(a.Entries, entry)
+	a.TotalSize += entry.Size
+}
+
+// modeEntryType converts file mode type bits to EntryType.
+func modeEntryType(mode os.FileMode) EntryType {
+	switch {
+	case mode.IsRegular():
+		return RegularEntry
+	case mode.IsDir():
+		return DirEntry
+	case mode&os.ModeSymlink != 0:
+		return SymlinkEntry
+	}
+	return OtherEntry
+}
+
+// tarEntryType converts tar type flag to EntryType.
+func tarEntryType(flag byte) EntryType {
+	switch flag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		return RegularEntry
+	case tar.TypeDir:
+		return DirEntry
+	case tar.TypeSymlink:
+		return SymlinkEntry
+	case tar.TypeLink:
+		return HardlinkEntry
+	}
+	return OtherEntry
+}
@@ -0,0 +1,84 @@
+package gnsys_test
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gnames/gnsys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListArchive(t *testing.T) {
+	assert := assert.New(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, v := range []string{
+		"data.zip", "data.tar.gz", "data.tar.bz2", "data.tar.xz",
+		"data.tar.zst", "data.tar.lz4", "data.tar.Z",
+	} {
+		res, err := gnsys.ListArchive(filepath.Join("testdata", v))
+		assert.Nil(err, v)
+		assert.Equal(int64(26), res.TotalSize, v)
+		assert.Len(res.Entries, 5, v)
+
+		entries := make(map[string]gnsys.ArchiveEntry)
+		for _, e := range res.Entries {
+			entries[e.Name] = e
+		}
+		dir := entries["data/sub/"]
+		assert.Equal(gnsys.DirEntry, dir.Type, v)
+		assert.True(dir.Mode.IsDir(), v)
+
+		file := entries["data/a.txt"]
+		assert.Equal(gnsys.RegularEntry, file.Type, v)
+		assert.Equal(int64(6), file.Size, v)
+		assert.Equal(os.FileMode(0644), file.Mode.Perm(), v)
+		// zip keeps MS-DOS time with 2 seconds resolution
+		assert.WithinDuration(mtime, file.ModTime, 2*time.Second, v)
+		if res.FileType == gnsys.ZipFT {
+			assert.Equal(int64(6), file.CompressedSize, v)
+		} else {
+			assert.Equal(int64(-1), file.CompressedSize, v)
+		}
+	}
+
+	tarPath := makeTar(t, []testEntry{
+		{name: "a.txt", body: "alpha"},
+		{name: "link.txt", typ: tar.TypeSymlink, link: "a.txt"},
+		{name: "hard.txt", typ: tar.TypeLink, link: "a.txt"},
+		{name: "pipe", typ: tar.TypeFifo},
+	})
+	res, err := gnsys.ListArchive(tarPath)
+	assert.Nil(err)
+	assert.Equal(gnsys.TarFT, res.FileType)
+	assert.Equal(int64(5), res.TotalSize)
+	types := []gnsys.EntryType{
+		gnsys.RegularEntry, gnsys.SymlinkEntry,
+		gnsys.HardlinkEntry, gnsys.OtherEntry,
+	}
+	for i, v := range res.Entries {
+		assert.Equal(types[i], v.Type, v.Name)
+	}
+	assert.Equal("a.txt", res.Entries[1].Link)
+	assert.Equal(int64(5), res.Entries[0].CompressedSize)
+
+	zipPath := filepath.Join(t.TempDir(), "data.zip")
+	err = gnsys.CreateZip([]string{makeSrcDir(t)}, zipPath)
+	assert.Nil(err)
+	res, err = gnsys.ListArchive(zipPath)
+	assert.Nil(err)
+	for _, v := range res.Entries {
+		if v.Name == "data/link.txt" {
+			assert.Equal(gnsys.SymlinkEntry, v.Type)
+			assert.Equal("a.txt", v.Link)
+		}
+	}
+
+	_, err = gnsys.ListArchive(filepath.Join("testdata", "text.txt.gz"))
+	assert.IsType(&gnsys.ErrExtract{}, err)
+	_, err = gnsys.ListArchive(filepath.Join("testdata", "nowhere.zip"))
+	assert.IsType(&gnsys.ErrFileMissing{}, err)
+}
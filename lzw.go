@@ -0,0 +1,205 @@
+package gnsys
+
+import (
+	"errors"
+	"io"
+)
+
+// Unix compress (.Z) format constants.
+const (
+	// lzwInitBits is the code width at the start and after a table reset.
+	lzwInitBits = 9
+
+	// lzwClear is the code that resets the table in block mode.
+	lzwClear = 256
+
+	// lzwBlockMode is a flag in the header that enables lzwClear code.
+	lzwBlockMode = 0x80
+
+	// lzwBitsMask extracts maximum code width from the header.
+	lzwBitsMask = 0x1f
+)
+
+var errLZWCorrupt = errors.New("corrupt compress (.Z) data")
+
+// zReader decompresses data created by Unix compress utility. It is an
+// LZW variant that is not compatible with compress/lzw: codes grow from
+// 9 up to 16 bits, the table can be reset by a clear code, and the input is
+// consumed in groups of 8 codes, so the rest of a group is skipped whenever
+// the code width changes.
+type zReader struct {
+	r io.Reader
+
+	maxBits    int
+	blockMode  bool
+	nBits      int
+	maxCode    int
+	maxMaxCode int
+	freeEnt    int
+	clearFlg   bool
+
+	// buf keeps the current group of codes, offset and size are in bits.
+	// Two extra bytes allow to read a code without bounds checks.
+	buf    [16 + 2]byte
+	offset int
+	size   int
+
+	prefix  []uint16
+	suffix  []byte
+	started bool
+	oldCode int
+	finChar byte
+
+	stack []byte
+	out   []byte
+	err   error
+}
+
+// newZReader reads the header of compressed data and returns a reader of
+// decompressed content.
+func newZReader(r io.Reader) (*zReader, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errLZWCorrupt
+	}
+	if header[0] != zMagic[0] || header[1] != zMagic[1] {
+		return nil, errors.New("not a compress (.Z) file")
+	}
+
+	maxBits := int(header[2] & lzwBitsMask)
+	if maxBits < lzwInitBits || maxBits > 16 {
+		return nil, errLZWCorrupt
+	}
+
+	res := &zReader{
+		r:          r,
+		maxBits:    maxBits,
+		blockMode:  header[2]&lzwBlockMode != 0,
+		nBits:      lzwInitBits,
+		maxCode:    1<<lzwInitBits - 1,
+		maxMaxCode: 1 << maxBits,
+		prefix:     make([]uint16, 1<<maxBits),
+		suffix:     make([]byte, 1<<maxBits),
+	}
+	res.freeEnt = lzwClear
+	if res.blockMode {
+		res.freeEnt = lzwClear + 1
+	}
+	return res, nil
+}
+
+// Read implements io.Reader.
+func (z *zReader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.decode()
+	}
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// decode reads one code and puts the string it represents to the output.
+func (z *zReader) decode() error {
+	code, err := z.getCode()
+	if err != nil {
+		return err
+	}
+
+	if !z.started {
+		if code > 255 {
+			return errLZWCorrupt
+		}
+		z.started = true
+		z.oldCode = code
+		z.finChar = byte(code)
+		z.out = append(z.out[:0], z.finChar)
+		return nil
+	}
+
+	if code == lzwClear && z.blockMode {
+		// The entry made by the next code is never used, this quirk
+		// keeps the numbering of entries in sync with compress.
+		z.clearFlg = true
+		z.freeEnt = lzwClear
+		return nil
+	}
+
+	inCode := code
+	stack := z.stack[:0]
+
+	// The code might refer to the entry that is being created (KwKwK case).
+	if code >= z.freeEnt {
+		if code > z.freeEnt {
+			return errLZWCorrupt
+		}
+		stack = append(stack, z.finChar)
+		code = z.oldCode
+	}
+
+	for code > 255 {
+		if len(stack) >= z.maxMaxCode {
+			return errLZWCorrupt
+		}
+		stack = append(stack, z.suffix[code])
+		code = int(z.prefix[code])
+	}
+	z.finChar = byte(code)
+	stack = append(stack, z.finChar)
+
+	z.out = z.out[:0]
+	for i := len(stack) - 1; i >= 0; i-- {
+		z.out = append(z.out, stack[i])
+	}
+	z.stack = stack
+
+	if z.freeEnt < z.maxMaxCode {
+		z.prefix[z.freeEnt] = uint16(z.oldCode)
+		z.suffix[z.freeEnt] = z.finChar
+		z.freeEnt++
+	}
+	z.oldCode = inCode
+	return nil
+}
+
+// getCode returns the next code from the input, reading a new group of
+// codes when the current one is exhausted or the code width changes.
+func (z *zReader) getCode() (int, error) {
+	if z.clearFlg || z.offset >= z.size || z.freeEnt > z.maxCode {
+		if z.freeEnt > z.maxCode {
+			z.nBits++
+			if z.nBits == z.maxBits {
+				z.maxCode = z.maxMaxCode
+			} else {
+				z.maxCode = 1<<z.nBits - 1
+			}
+		}
+		if z.clearFlg {
+			z.nBits = lzwInitBits
+			z.maxCode = 1<<lzwInitBits - 1
+			z.clearFlg = false
+		}
+
+		n, err := io.ReadFull(z.r, z.buf[:z.nBits])
+		if n == 0 {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		z.offset = 0
+		// Do not read a code from the incomplete bits at the end.
+		z.size = n<<3 - (z.nBits - 1)
+	}
+
+	i := z.offset >> 3
+	bits := int(z.buf[i]) | int(z.buf[i+1])<<8 | int(z.buf[i+2])<<16
+	code := bits >> (z.offset & 7) & (1<<z.nBits - 1)
+	z.offset += z.nBits
+	return code, nil
+}
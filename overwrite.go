@@ -0,0 +1,132 @@
+package gnsys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OverwritePolicy determines what extraction does with files that already
+// exist at the place of extracted entries. Directories are merged
+// regardless of the policy.
+type OverwritePolicy int
+
+const (
+	// OverwriteExisting replaces existing files. It is the default.
+	OverwriteExisting OverwritePolicy = iota
+
+	// SkipExisting keeps existing files and skips entries.
+	SkipExisting
+
+	// OverwriteOlder replaces existing files only if the entry has a later
+	// modification time. Entries without modification time are skipped.
+	OverwriteOlder
+
+	// FailExisting stops extraction with an ErrExtract.
+	FailExisting
+
+	// RenameNew keeps existing files and saves entries under a free name
+	// with a number before the extension ("names.1.csv").
+	RenameNew
+)
+
+// ExtractReport lists entries that were skipped or renamed according to
+// the overwrite policy. Entries of archives are reported by their names in
+// the archive, renamed entries by their paths relative to the destination
+// directory. Single compressed files are reported by the path of the
+// result. The order is not guaranteed for parallel extraction.
+type ExtractReport struct {
+	// Skipped are entries that were not extracted, because files with
+	// the same name exist.
+	Skipped []string
+
+	// Renamed maps entries to the paths they were saved under.
+	Renamed map[string]string
+}
+
+// OptOverwrite sets the policy for existing files. With OptAtomic it
+// applies to the files of the destination directory the staged entries
+// would replace.
+func OptOverwrite(p OverwritePolicy) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.overwrite = p
+	}
+}
+
+// OptReport fills the report with entries that were skipped or renamed
+// because of existing files.
+func OptReport(report *ExtractReport) ExtractOption {
+	return func(cfg *extractConfig) {
+		cfg.report = report
+	}
+}
+
+// skip adds a skipped entry to the report, if there is one.
+func (r *ExtractReport) skip(entry string) {
+	if r == nil {
+		return
+	}
+	r.Skipped = append(r.Skipped, entry)
+}
+
+// rename adds a renamed entry to the report, if there is one.
+func (r *ExtractReport) rename(entry, name string) {
+	if r == nil {
+		return
+	}
+	if r.Renamed == nil {
+		r.Renamed = make(map[string]string)
+	}
+	r.Renamed[entry] = name
+}
+
+// resolveExisting applies the overwrite policy to a file that exists at
+// the place of an entry. The mtime is the modification time of the entry
+// and exists checks if a file with a given name is present. It returns
+// the name to write the entry to, or an empty string if the entry is
+// skipped.
+func resolveExisting(
+	cfg *extractConfig,
+	entry, name string,
+	fi os.FileInfo,
+	mtime time.Time,
+	exists func(string) bool,
+) (string, error) {
+	switch cfg.overwrite {
+	case SkipExisting:
+		cfg.report.skip(entry)
+		return "", nil
+	case OverwriteOlder:
+		if mtime.After(fi.ModTime()) {
+			return name, nil
+		}
+		cfg.report.skip(entry)
+		return "", nil
+	case FailExisting:
+		return "", fmt.Errorf("file '%s' already exists", name)
+	case RenameNew:
+		res := freeName(name, exists)
+		cfg.report.rename(entry, filepath.ToSlash(res))
+		return res, nil
+	}
+	return name, nil
+}
+
+// freeName adds the first number that gives a name of no existing file
+// before the extension of the name.
+func freeName(name string, exists func(string) bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	// Hidden files like ".profile" have no extension.
+	if base == "" || os.IsPathSeparator(base[len(base)-1]) {
+		base, ext = name, ""
+	}
+	for i := 1; ; i++ {
+		res := fmt.Sprintf("%s.%d%s", base, i, ext)
+		if !exists(res) {
+			return res
+		}
+	}
+}
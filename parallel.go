@@ -0,0 +1,107 @@
+package gnsys
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// zipJob is a zip entry waiting to be extracted under the given name.
+type zipJob struct {
+	f    *zip.File
+	name string
+}
+
+// unzipParallel extracts files of a zip archive selected by checkZip with
+// a pool of workers. Directories are created serially beforehand, so
+// workers only write files. If several entries have the same name, only the last one
+// is extracted, as it would win in serial extraction. Symbolic links are
+// created after all files, so files are never written through them.
+func (e *extraction) unzipParallel(r *zip.Reader, names []string) error {
+	var pending []zipJob
+	last := make(map[string]int)
+	for i, f := range r.File {
+		e.progress.entry()
+		name := names[i]
+		if name == "" {
+			e.progress.add(int64(f.CompressedSize64))
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			e.progress.add(int64(f.CompressedSize64))
+			if err := e.zipEntry(f, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.mkdirAll(filepath.Dir(name)); err != nil {
+			return e.entryErr(f.Name, err)
+		}
+		last[name] = len(pending)
+		pending = append(pending, zipJob{f: f, name: name})
+	}
+
+	var files, links []zipJob
+	for i, v := range pending {
+		switch {
+		case last[v.name] != i:
+			e.progress.add(int64(v.f.CompressedSize64))
+		case v.f.Mode()&os.ModeSymlink != 0:
+			links = append(links, v)
+		default:
+			files = append(files, v)
+		}
+	}
+
+	if err := e.unzipFiles(files); err != nil {
+		return err
+	}
+	for _, v := range links {
+		e.progress.add(int64(v.f.CompressedSize64))
+		if err := e.zipEntry(v.f, v.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unzipFiles writes regular files of a zip archive concurrently. The first
+// error stops the distribution of remaining files and is returned after
+// all workers are finished.
+func (e *extraction) unzipFiles(files []zipJob) error {
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	jobs := make(chan zipJob)
+	stop := make(chan struct{})
+	for range min(e.cfg.workers, len(files)) {
+		wg.Go(func() {
+			for job := range jobs {
+				err := e.zipEntry(job.f, job.name)
+				e.progress.add(int64(job.f.CompressedSize64))
+				if err != nil {
+					once.Do(func() {
+						first = err
+						close(stop)
+					})
+				}
+			}
+		})
+	}
+
+send:
+	for _, v := range files {
+		select {
+		case jobs <- v:
+		case <-stop:
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return first
+}
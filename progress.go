@@ -0,0 +1,89 @@
+package gnsys
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// progress shows how far an extraction went. The bar counts compressed
+// bytes consumed from the source, so the estimated time is meaningful for
+// streams too, and the suffix counts processed entries. A nil progress
+// shows nothing, so callers do not need to check if it is enabled.
+type progress struct {
+	bar     *pb.ProgressBar
+	entries int
+}
+
+// newProgress starts a progress bar for a source of the given compressed
+// size, if it is enabled. Unknown size is zero.
+func newProgress(cfg *extractConfig, size int64) *progress {
+	if !cfg.progress {
+		return nil
+	}
+	bar := pb.Full.Start64(size)
+	bar.Set(pb.CleanOnFinish, true)
+	return &progress{bar: bar}
+}
+
+// reader counts data read from r as consumed.
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return p.bar.NewProxyReader(r)
+}
+
+// add counts n bytes as consumed.
+func (p *progress) add(n int64) {
+	if p == nil {
+		return
+	}
+	p.bar.Add64(n)
+}
+
+// entry counts a processed archive entry.
+func (p *progress) entry() {
+	if p == nil {
+		return
+	}
+	p.entries++
+	p.bar.Set("suffix", fmt.Sprintf("%d entries", p.entries))
+}
+
+// finish removes the progress bar.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.bar.Finish()
+}
+
+// sizedReader is a reader that knows the size of its remaining data.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+// streamSize returns the size of data left in r, or zero if it is unknown.
+func streamSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case *sizedReader:
+		return v.size
+	case *os.File:
+		fi, err := v.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0
+		}
+		pos, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0
+		}
+		return fi.Size() - pos
+	case interface{ Len() int }:
+		return int64(v.Len())
+	}
+	return 0
+}
@@ -0,0 +1,497 @@
+package gnsys
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// s3DefaultRegion is used when region is not set in options or
+	// environment.
+	s3DefaultRegion = "us-east-1"
+
+	// s3DefaultWorkers is the number of parallel range requests.
+	s3DefaultWorkers = 4
+
+	// s3DefaultPartSize is the size of one range request.
+	s3DefaultPartSize = 8 << 20
+
+	// emptySHA256 is a SHA-256 hash of an empty payload.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// OptS3Endpoint sets the endpoint of S3-compatible storage, for example
+// "http://localhost:9000". Objects are addressed in path style
+// (endpoint/bucket/key) when the endpoint is set. By default AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL environment variables are used, and if they are empty,
+// the AWS endpoint for the region.
+func OptS3Endpoint(endpoint string) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.s3Endpoint = endpoint
+	}
+}
+
+// OptS3Region sets the region used for signing S3 requests. By default
+// AWS_REGION or AWS_DEFAULT_REGION environment variables are used,
+// falling back to "us-east-1".
+func OptS3Region(region string) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.s3Region = region
+	}
+}
+
+// OptS3Workers sets how many range requests run in parallel during an s3://
+// download.
+func OptS3Workers(n int) DownloadOption {
+	return func(cfg *downloadConfig) {
+		if n > 0 {
+			cfg.s3Workers = n
+		}
+	}
+}
+
+// OptS3PartSize sets the size in bytes of a range fetched by one request
+// during an s3:// download.
+func OptS3PartSize(size int64) DownloadOption {
+	return func(cfg *downloadConfig) {
+		if size > 0 {
+			cfg.s3PartSize = size
+		}
+	}
+}
+
+// s3Credentials are keys used to sign requests. Empty credentials mean
+// anonymous access.
+type s3Credentials struct {
+	accessKey, secretKey, sessionToken string
+}
+
+// s3Client performs signed requests to S3-compatible storage.
+type s3Client struct {
+	endpoint  *url.URL
+	pathStyle bool
+	region    string
+	creds     s3Credentials
+}
+
+// openS3Source opens an s3://bucket/key URL. The object is fetched with
+// several parallel range requests that are delivered in order.
+func openS3Source(
+	u *url.URL,
+	offset int64,
+	cfg *downloadConfig,
+) (*source, error) {
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("S3 URL must be 's3://bucket/key': %s", u)
+	}
+
+	client, err := newS3Client(bucket, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := client.objectSize(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if offset > size {
+		offset = 0
+	}
+
+	workers := cfg.s3Workers
+	if workers == 0 {
+		workers = s3DefaultWorkers
+	}
+	partSize := cfg.s3PartSize
+	if partSize == 0 {
+		partSize = s3DefaultPartSize
+	}
+
+	fetch := func(ctx context.Context, start, end int64) ([]byte, error) {
+		return client.objectRange(ctx, bucket, key, start, end)
+	}
+	rr := newRangeReader(fetch, offset, size, partSize, workers)
+
+	res := &source{
+		reader: rr,
+		size:   size,
+		offset: offset,
+		close:  rr.Close,
+	}
+	return res, nil
+}
+
+// newS3Client creates a client using options, environment variables and
+// AWS shared credentials file.
+func newS3Client(bucket string, cfg *downloadConfig) (*s3Client, error) {
+	region := firstNonEmpty(
+		cfg.s3Region,
+		os.Getenv("AWS_REGION"),
+		os.Getenv("AWS_DEFAULT_REGION"),
+		s3DefaultRegion,
+	)
+
+	creds, err := s3LoadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &s3Client{region: region, creds: creds}
+
+	endpoint := firstNonEmpty(
+		cfg.s3Endpoint,
+		os.Getenv("AWS_ENDPOINT_URL_S3"),
+		os.Getenv("AWS_ENDPOINT_URL"),
+	)
+	if endpoint != "" {
+		res.pathStyle = true
+	} else {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+		// Buckets with dots do not match wildcard TLS certificates.
+		res.pathStyle = strings.Contains(bucket, ".")
+	}
+
+	res.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// s3LoadCredentials reads credentials from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables,
+// or from the AWS_PROFILE section of the shared credentials file.
+func s3LoadCredentials() (s3Credentials, error) {
+	res := s3Credentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if res.accessKey != "" && res.secretKey != "" {
+		return res, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return s3Credentials{}, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := firstNonEmpty(os.Getenv("AWS_PROFILE"), "default")
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s3Credentials{}, nil
+	}
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	defer f.Close()
+
+	res = s3Credentials{}
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			res.accessKey = v
+		case "aws_secret_access_key":
+			res.secretKey = v
+		case "aws_session_token":
+			res.sessionToken = v
+		}
+	}
+	return res, scanner.Err()
+}
+
+// objectURL returns URL of an object in path or virtual-hosted style.
+func (c *s3Client) objectURL(bucket, key string) (*url.URL, error) {
+	u := *c.endpoint
+	path := "/" + s3Escape(key, false)
+	if c.pathStyle {
+		path = strings.TrimSuffix(u.EscapedPath(), "/") +
+			"/" + s3Escape(bucket, true) + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	return url.Parse(u.Scheme + "://" + u.Host + path)
+}
+
+// objectSize finds the size of an object with a HEAD request.
+func (c *s3Client) objectSize(bucket, key string) (int64, error) {
+	resp, err := c.do(context.Background(), http.MethodHead, bucket, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, errors.New("S3 server did not report object size")
+	}
+	return resp.ContentLength, nil
+}
+
+// objectRange fetches bytes from start to end inclusive.
+func (c *s3Client) objectRange(
+	ctx context.Context,
+	bucket, key string,
+	start, end int64,
+) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(res)) != end-start+1 {
+		return nil, fmt.Errorf(
+			"S3 range %d-%d returned %d bytes", start, end, len(res),
+		)
+	}
+	return res, nil
+}
+
+// do sends a signed request and checks its status.
+func (c *s3Client) do(
+	ctx context.Context,
+	method, bucket, key string,
+	header http.Header,
+) (*http.Response, error) {
+	u, err := c.objectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.sign(req, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf(
+			"S3 request failed: server returned status %d",
+			resp.StatusCode,
+		)
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 authorization to a request without
+// a body. Requests stay unsigned if there are no credentials.
+func (c *s3Client) sign(req *http.Request, t time.Time) {
+	if c.creds.accessKey == "" {
+		return
+	}
+	amzDate := t.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if c.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	reqHash := sha256.Sum256([]byte(canonRequest))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.creds.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+
+		c.creds.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape encodes a bucket name or an object key according to SigV4 rules.
+// Slashes are encoded only if encodeSlash is true.
+func s3Escape(s string, encodeSlash bool) string {
+	var res strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			res.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			res.WriteByte(b)
+		default:
+			fmt.Fprintf(&res, "%%%02X", b)
+		}
+	}
+	return res.String()
+}
+
+func firstNonEmpty(strs ...string) string {
+	for _, v := range strs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// rangeFetcher downloads bytes from start to end inclusive.
+type rangeFetcher func(ctx context.Context, start, end int64) ([]byte, error)
+
+// rangePart is a result of fetching one range.
+type rangePart struct {
+	data []byte
+	err  error
+}
+
+// rangeReader reads a remote object sequentially while fetching its parts
+// with several parallel range requests. At most `workers` parts are kept
+// in memory at any time.
+type rangeReader struct {
+	queue  chan chan rangePart
+	sem    chan struct{}
+	cancel context.CancelFunc
+	cur    []byte
+	err    error
+}
+
+func newRangeReader(
+	fetch rangeFetcher,
+	offset, size, partSize int64,
+	workers int,
+) *rangeReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	rr := &rangeReader{
+		queue:  make(chan chan rangePart, workers),
+		sem:    make(chan struct{}, workers),
+		cancel: cancel,
+	}
+
+	go func() {
+		defer close(rr.queue)
+		for start := offset; start < size; start += partSize {
+			end := min(start+partSize, size) - 1
+			select {
+			case rr.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			ch := make(chan rangePart, 1)
+			rr.queue <- ch
+			go func(start, end int64) {
+				data, err := fetch(ctx, start, end)
+				ch <- rangePart{data: data, err: err}
+			}(start, end)
+		}
+	}()
+
+	return rr
+}
+
+// Read implements io.Reader.
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	for len(rr.cur) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		ch, ok := <-rr.queue
+		if !ok {
+			rr.err = io.EOF
+			continue
+		}
+		part := <-ch
+		<-rr.sem
+		rr.cur, rr.err = part.data, part.err
+	}
+	n := copy(p, rr.cur)
+	rr.cur = rr.cur[n:]
+	return n, nil
+}
+
+// Close stops fetching of the remaining parts.
+func (rr *rangeReader) Close() error {
+	rr.cancel()
+	// Wait for the producer to stop, releasing parts that were not read.
+	for range rr.queue {
+		<-rr.sem
+	}
+	return nil
+}
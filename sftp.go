@@ -0,0 +1,232 @@
+package gnsys
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSSHKeys are private keys tried for sftp:// authentication when
+// no keys are given explicitly. The paths are relative to home directory.
+var defaultSSHKeys = []string{
+	".ssh/id_ed25519",
+	".ssh/id_ecdsa",
+	".ssh/id_rsa",
+}
+
+// openSFTPSource connects to an SSH server and opens the remote file for
+// reading. Authentication uses ssh-agent and private key files, the server
+// is verified against known_hosts.
+func openSFTPSource(
+	u *url.URL,
+	offset int64,
+	cfg *downloadConfig,
+) (*source, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	sshCfg, closeAgent, err := sshClientConfig(u, addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	conn, err := ssh.Dial("tcp", addr, sshCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Paths starting with "/~/" are relative to the user's home directory.
+	path := u.Path
+	if strings.HasPrefix(path, "/~/") {
+		path = path[3:]
+	}
+
+	f, err := client.Open(path)
+	if err != nil {
+		client.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	closeAll := func() error {
+		f.Close()
+		client.Close()
+		return conn.Close()
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	size := fi.Size()
+
+	if offset > size {
+		offset = 0
+	}
+	if _, err = f.Seek(offset, 0); err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	res := &source{
+		reader: f,
+		size:   size,
+		offset: offset,
+		close:  closeAll,
+	}
+	return res, nil
+}
+
+// sshClientConfig creates SSH configuration for the given URL. The returned
+// function closes connection to ssh-agent, if there was one.
+func sshClientConfig(
+	u *url.URL,
+	addr string,
+	cfg *downloadConfig,
+) (*ssh.ClientConfig, func(), error) {
+	closeAgent := func() {}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, closeAgent, err
+	}
+
+	knownHosts := cfg.knownHostsFile
+	if knownHosts == "" {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, closeAgent, err
+	}
+
+	userName := u.User.Username()
+	if userName == "" {
+		userName = os.Getenv("USER")
+	}
+
+	var signers []ssh.Signer
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			closeAgent = func() { conn.Close() }
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+
+	keyFiles := cfg.sshKeyFiles
+	if len(keyFiles) == 0 {
+		for _, v := range defaultSSHKeys {
+			keyFiles = append(keyFiles, filepath.Join(home, v))
+		}
+	}
+	keySigners, err := sshKeySigners(keyFiles, len(cfg.sshKeyFiles) > 0)
+	if err != nil {
+		closeAgent()
+		return nil, func() {}, err
+	}
+	signers = append(signers, keySigners...)
+
+	// All public keys go into one method, because SSH client tries every
+	// authentication method only once.
+	var auth []ssh.AuthMethod
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if pass, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(pass))
+	}
+	if len(auth) == 0 {
+		closeAgent()
+		err = errors.New("no SSH keys or ssh-agent found for authentication")
+		return nil, func() {}, err
+	}
+
+	res := &ssh.ClientConfig{
+		User:              userName,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms(hostKeyCallback, addr),
+	}
+	return res, closeAgent, nil
+}
+
+// sshKeySigners reads private keys from files. If strict is false,
+// missing files and passphrase-protected keys are silently skipped.
+func sshKeySigners(paths []string, strict bool) ([]ssh.Signer, error) {
+	var res []ssh.Signer
+	for _, path := range paths {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			if !strict && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			var errPass *ssh.PassphraseMissingError
+			if !strict && errors.As(err, &errPass) {
+				continue
+			}
+			return nil, fmt.Errorf("cannot parse SSH key '%s': %w", path, err)
+		}
+		res = append(res, signer)
+	}
+	return res, nil
+}
+
+// hostKeyAlgorithms returns algorithms of host keys known for the address.
+// Without them the server might offer a key of a different type than the
+// one recorded in known_hosts, and the verification would fail.
+func hostKeyAlgorithms(cb ssh.HostKeyCallback, addr string) []string {
+	// A dummy key never matches, so the error lists the known keys.
+	fakeAddr := &net.TCPAddr{IP: net.IPv4zero}
+	err := cb(addr, fakeAddr, fakeKey{})
+
+	var errKey *knownhosts.KeyError
+	if !errors.As(err, &errKey) {
+		return nil
+	}
+
+	var res []string
+	for _, v := range errKey.Want {
+		algo := v.Key.Type()
+		if algo == ssh.KeyAlgoRSA {
+			res = append(res, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		res = append(res, algo)
+	}
+	return res
+}
+
+// fakeKey is a public key that does not match any real key.
+type fakeKey struct{}
+
+func (fakeKey) Type() string                        { return "fake" }
+func (fakeKey) Marshal() []byte                     { return []byte("fake") }
+func (fakeKey) Verify([]byte, *ssh.Signature) error { return errors.New("fake") }
@@ -0,0 +1,194 @@
+package gnsys_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gnames/gnsys"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestDownloadSFTP(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	srcDir := t.TempDir()
+	content := []byte(strings.Repeat("sftp download content\n", 1000))
+	srcPath := filepath.Join(srcDir, "data.txt")
+	err := os.WriteFile(srcPath, content, 0644)
+	assert.Nil(err)
+
+	srv := startSFTPServer(t)
+	url := "sftp://tester@" + srv.addr + srcPath
+
+	t.Run("full", func(t *testing.T) {
+		destDir := t.TempDir()
+		path, err := gnsys.Download(url, destDir, false,
+			gnsys.OptSSHKeyFiles(srv.keyFile),
+			gnsys.OptKnownHosts(srv.knownHosts),
+		)
+		assert.Nil(err)
+		res, err := os.ReadFile(path)
+		assert.Nil(err)
+		assert.Equal(content, res)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		destDir := t.TempDir()
+		partial := filepath.Join(destDir, "data.txt")
+		err := os.WriteFile(partial, content[:5000], 0644)
+		assert.Nil(err)
+
+		path, err := gnsys.Download(url, destDir, false,
+			gnsys.OptSSHKeyFiles(srv.keyFile),
+			gnsys.OptKnownHosts(srv.knownHosts),
+			gnsys.OptResume(true),
+		)
+		assert.Nil(err)
+		res, err := os.ReadFile(path)
+		assert.Nil(err)
+		assert.Equal(content, res)
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		emptyHosts := filepath.Join(t.TempDir(), "known_hosts")
+		err := os.WriteFile(emptyHosts, nil, 0644)
+		assert.Nil(err)
+
+		_, err = gnsys.Download(url, t.TempDir(), false,
+			gnsys.OptSSHKeyFiles(srv.keyFile),
+			gnsys.OptKnownHosts(emptyHosts),
+		)
+		assert.NotNil(err)
+		assert.IsType(&gnsys.ErrDownload{}, err)
+	})
+}
+
+type sftpServer struct {
+	addr, keyFile, knownHosts string
+}
+
+// startSFTPServer runs an in-process SSH server with sftp subsystem that
+// accepts one generated client key.
+func startSFTPServer(t *testing.T) sftpServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(
+			_ ssh.ConnMetadata,
+			key ssh.PublicKey,
+		) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, cfg)
+		}
+	}()
+
+	addr := ln.Addr().String()
+	line := knownhosts.Line(
+		[]string{knownhosts.Normalize(addr)},
+		hostSigner.PublicKey(),
+	)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	err = os.WriteFile(knownHosts, []byte(line+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sftpServer{addr: addr, keyFile: keyFile, knownHosts: knownHosts}
+}
+
+func serveSFTP(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chReqs {
+				var subsystem struct{ Name string }
+				ok := req.Type == "subsystem" &&
+					ssh.Unmarshal(req.Payload, &subsystem) == nil &&
+					subsystem.Name == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				srv, err := sftp.NewServer(ch)
+				if err != nil {
+					ch.Close()
+					return
+				}
+				srv.Serve()
+				srv.Close()
+				return
+			}
+		}()
+	}
+}
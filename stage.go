@@ -0,0 +1,114 @@
+package gnsys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// newStage creates a hidden staging directory next to dstDir. It is on
+// the same filesystem as dstDir, so its content can be renamed into it.
+func newStage(dstDir string) (string, error) {
+	dstDir, err := filepath.Abs(dstDir)
+	if err != nil {
+		return "", err
+	}
+	parent := filepath.Dir(dstDir)
+	if parent == dstDir {
+		err = fmt.Errorf("no place for a staging directory next to '%s'", dstDir)
+		return "", err
+	}
+	if err = os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	res, err := os.MkdirTemp(parent, "."+filepath.Base(dstDir)+".gnsys-*")
+	if err != nil {
+		return "", err
+	}
+	if err = os.Chmod(res, 0755); err != nil {
+		os.Remove(res)
+		return "", err
+	}
+	return res, nil
+}
+
+// mergeStage moves the content of a staging directory to dstDir. If dstDir
+// does not exist, the staging directory takes its place. Otherwise files of
+// the stage replace files with the same names in dstDir, and directories are
+// merged. Files of dstDir that are not in the stage are kept. Conflicts of
+// files with directories are found before anything is moved, so dstDir
+// stays untouched if there are any.
+func mergeStage(stage, dstDir string) error {
+	dstDir, err := filepath.Abs(dstDir)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Lstat(dstDir); os.IsNotExist(err) {
+		return os.Rename(stage, dstDir)
+	}
+	if err = mergeDir(stage, dstDir, false); err != nil {
+		return err
+	}
+	return mergeDir(stage, dstDir, true)
+}
+
+// mergeDir moves entries of the src directory into the dst directory. If
+// move is false, it only checks that nothing is in the way. Directories are
+// merged only with real directories, not with symbolic links to them.
+func mergeDir(src, dst string, move bool) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, v := range entries {
+		srcPath := filepath.Join(src, v.Name())
+		dstPath := filepath.Join(dst, v.Name())
+		fi, err := os.Lstat(dstPath)
+		switch {
+		case os.IsNotExist(err):
+			err = nil
+			if move {
+				err = os.Rename(srcPath, dstPath)
+			}
+		case err != nil:
+		case v.IsDir() && fi.IsDir():
+			err = mergeDir(srcPath, dstPath, move)
+		case v.IsDir():
+			err = fmt.Errorf("'%s' is not a directory", dstPath)
+		case fi.IsDir():
+			err = fmt.Errorf("'%s' is a directory", dstPath)
+		case move:
+			err = os.Rename(srcPath, dstPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagedFS shows files of a staging directory on top of files of the
+// destination directory, as they will be after the merge. The dst is nil if
+// the destination directory does not exist yet.
+type stagedFS struct {
+	stage, dst *os.Root
+}
+
+// Lstat implements linkFS.
+func (s stagedFS) Lstat(name string) (os.FileInfo, error) {
+	fi, err := s.stage.Lstat(name)
+	if s.dst == nil || !errors.Is(err, os.ErrNotExist) {
+		return fi, err
+	}
+	return s.dst.Lstat(name)
+}
+
+// Readlink implements linkFS.
+func (s stagedFS) Readlink(name string) (string, error) {
+	res, err := s.stage.Readlink(name)
+	if s.dst == nil || !errors.Is(err, os.ErrNotExist) {
+		return res, err
+	}
+	return s.dst.Readlink(name)
+}
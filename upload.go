@@ -0,0 +1,275 @@
+package gnsys
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// uploadRetryDelay is the delay before the first retry of a failed upload.
+// Every next retry waits twice as long.
+const uploadRetryDelay = 500 * time.Millisecond
+
+// UploadOption is a function that configures Upload.
+type UploadOption func(*uploadConfig)
+
+// uploadConfig keeps settings that modify behavior of Upload.
+type uploadConfig struct {
+	// formField is the name of a form field for multipart POST upload.
+	// If it is empty, the file is sent as a body of a PUT request.
+	formField string
+
+	// retries is the number of attempts to repeat a failed upload.
+	retries int
+
+	// header contains additional request headers.
+	header http.Header
+}
+
+// OptUploadMultipart sets Upload to send the file with a multipart/form-data
+// POST request, using the given name of a form field. By default the file
+// is sent as the body of a PUT request.
+func OptUploadMultipart(field string) UploadOption {
+	return func(cfg *uploadConfig) {
+		cfg.formField = field
+	}
+}
+
+// OptUploadRetries sets how many times a failed upload is repeated.
+// Uploads are retried after network errors and 408, 429 and 5xx responses,
+// with an exponentially growing delay between attempts.
+func OptUploadRetries(n int) UploadOption {
+	return func(cfg *uploadConfig) {
+		cfg.retries = n
+	}
+}
+
+// OptUploadHeader adds a header to the upload request, for example
+// an authorization token.
+func OptUploadHeader(key, value string) UploadOption {
+	return func(cfg *uploadConfig) {
+		cfg.header.Add(key, value)
+	}
+}
+
+// Upload sends a local file to a server. It supports http:// and https://
+// URL schemes.
+//
+// Parameters:
+//   - ctx: Context that allows to cancel the upload.
+//   - srcPath: The path to the file to upload.
+//   - rawURL: The destination URL.
+//   - showProgress: When true, displays a progress bar during upload.
+//     The progress bar clears itself upon completion.
+//   - opts: Optional settings, for example OptUploadMultipart.
+//
+// The request contains Content-MD5 (base64) and X-Checksum-Sha256 (hex)
+// headers of the file, so the server can verify the received data.
+// For multipart uploads these headers are set on the file part.
+//
+// On error, returns an ErrUpload wrapping the underlying error.
+func Upload(
+	ctx context.Context,
+	srcPath, rawURL string,
+	showProgress bool,
+	opts ...UploadOption,
+) error {
+	cfg := uploadConfig{header: http.Header{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return &ErrUpload{URL: rawURL, Err: err}
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		err = fmt.Errorf("unsupported URL scheme: %s", parsedURL.Scheme)
+		return &ErrUpload{URL: rawURL, Err: err}
+	}
+
+	exists, _ := FileExists(srcPath)
+	if !exists {
+		return &ErrFileMissing{Path: srcPath}
+	}
+
+	sums, err := fileChecksums(srcPath)
+	if err != nil {
+		return &ErrUpload{URL: rawURL, Err: err}
+	}
+
+	delay := uploadRetryDelay
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = uploadOnce(ctx, srcPath, rawURL, showProgress, sums, &cfg)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= cfg.retries {
+			return &ErrUpload{URL: rawURL, Err: err}
+		}
+
+		select {
+		case <-ctx.Done():
+			return &ErrUpload{URL: rawURL, Err: ctx.Err()}
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// checksums keeps checksum headers of an uploaded file.
+type checksums struct {
+	md5, sha256 string
+}
+
+// fileChecksums calculates MD5 and SHA-256 checksums of a file in one pass.
+func fileChecksums(path string) (checksums, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return checksums{}, err
+	}
+	defer f.Close()
+
+	hMD5 := md5.New()
+	hSHA := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(hMD5, hSHA), f); err != nil {
+		return checksums{}, err
+	}
+
+	res := checksums{
+		md5:    base64.StdEncoding.EncodeToString(hMD5.Sum(nil)),
+		sha256: hex.EncodeToString(hSHA.Sum(nil)),
+	}
+	return res, nil
+}
+
+// uploadOnce makes one attempt to upload a file. It returns true if the
+// failed attempt is worth repeating.
+func uploadOnce(
+	ctx context.Context,
+	srcPath, rawURL string,
+	showProgress bool,
+	sums checksums,
+	cfg *uploadConfig,
+) (bool, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	var reader io.Reader = f
+	if showProgress {
+		bar := pb.Full.Start64(fi.Size())
+		bar.Set(pb.CleanOnFinish, true)
+		reader = bar.NewProxyReader(reader)
+		defer bar.Finish()
+	}
+
+	method := http.MethodPut
+	size := fi.Size()
+	header := http.Header{}
+	if cfg.formField == "" {
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-MD5", sums.md5)
+		header.Set("X-Checksum-Sha256", sums.sha256)
+	} else {
+		var prefix, suffix []byte
+		var contentType string
+		prefix, suffix, contentType, err = multipartFrame(
+			cfg.formField, filepath.Base(srcPath), sums,
+		)
+		if err != nil {
+			return false, err
+		}
+		method = http.MethodPost
+		size += int64(len(prefix) + len(suffix))
+		header.Set("Content-Type", contentType)
+		reader = io.MultiReader(
+			bytes.NewReader(prefix), reader, bytes.NewReader(suffix),
+		)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+	for k, v := range cfg.header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode >= 500
+		err = fmt.Errorf(
+			"upload failed: server returned status %d",
+			resp.StatusCode,
+		)
+		return retry, err
+	}
+	return false, nil
+}
+
+// multipartFrame creates the parts of multipart/form-data body that go
+// before and after the file content. It allows to stream the file and
+// still know the size of the body.
+func multipartFrame(
+	field, fileName string,
+	sums checksums,
+) ([]byte, []byte, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="%s"; filename="%s"`,
+		quote.Replace(field), quote.Replace(fileName),
+	))
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-MD5", sums.md5)
+	h.Set("X-Checksum-Sha256", sums.sha256)
+	if _, err := mw.CreatePart(h); err != nil {
+		return nil, nil, "", err
+	}
+	prefix := bytes.Clone(buf.Bytes())
+	buf.Reset()
+
+	if err := mw.Close(); err != nil {
+		return nil, nil, "", err
+	}
+	return prefix, buf.Bytes(), mw.FormDataContentType(), nil
+}
@@ -0,0 +1,113 @@
+package gnsys_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gnames/gnsys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpload(t *testing.T) {
+	assert := assert.New(t)
+	content := []byte("Hello, this is test content for upload")
+	srcPath := filepath.Join(t.TempDir(), "upload.txt")
+	err := os.WriteFile(srcPath, content, 0644)
+	assert.Nil(err)
+	sum := md5.Sum(content)
+	md5Header := base64.StdEncoding.EncodeToString(sum[:])
+
+	t.Run("put", func(t *testing.T) {
+		var got []byte
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(http.MethodPut, r.Method)
+				assert.Equal(md5Header, r.Header.Get("Content-MD5"))
+				assert.Equal("secret", r.Header.Get("Authorization"))
+				got, _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			},
+		))
+		defer srv.Close()
+
+		err := gnsys.Upload(context.Background(), srcPath, srv.URL+"/f", false,
+			gnsys.OptUploadHeader("Authorization", "secret"),
+		)
+		assert.Nil(err)
+		assert.Equal(content, got)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		var got []byte
+		var name, partMD5 string
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(http.MethodPost, r.Method)
+				f, h, err := r.FormFile("data")
+				assert.Nil(err)
+				defer f.Close()
+				name = h.Filename
+				partMD5 = h.Header.Get("Content-MD5")
+				got, _ = io.ReadAll(f)
+			},
+		))
+		defer srv.Close()
+
+		err := gnsys.Upload(context.Background(), srcPath, srv.URL, false,
+			gnsys.OptUploadMultipart("data"),
+		)
+		assert.Nil(err)
+		assert.Equal(content, got)
+		assert.Equal("upload.txt", name)
+		assert.Equal(md5Header, partMD5)
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			},
+		))
+		defer srv.Close()
+
+		err := gnsys.Upload(context.Background(), srcPath, srv.URL, false)
+		assert.IsType(&gnsys.ErrUpload{}, err)
+		assert.Equal(int32(1), calls.Load())
+
+		calls.Store(0)
+		err = gnsys.Upload(context.Background(), srcPath, srv.URL, false,
+			gnsys.OptUploadRetries(2),
+		)
+		assert.Nil(err)
+		assert.Equal(int32(2), calls.Load())
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := gnsys.Upload(ctx, srcPath, srv.URL, false,
+			gnsys.OptUploadRetries(5),
+		)
+		assert.IsType(&gnsys.ErrUpload{}, err)
+		assert.True(errors.Is(err.(*gnsys.ErrUpload).Err, context.Canceled))
+	})
+}